@@ -2,7 +2,7 @@ package golib_vcard
 
 type BJSON struct {
 	Profile string   `vdir:"json,profile"`
-	SMSDATA []string `vdir:SMSDATA`
+	SMSDATA []string `vdir:"smsdata"`
 	Contact string
 	DATE    string
 }
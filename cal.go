@@ -10,6 +10,8 @@ type Calendar struct {
 	ToDos    []Todo     `vdir:"vtodo,object"`
 	Journals []Journal  `vdir:"vjournal,object"`
 	FreeBusy []FreeBusy `vdir:"vfreebusy,object"`
+
+	Extra []*ContentLine `vdir:",extra" json:",omitempty"` //未映射的属性，如X-WR-CALNAME
 }
 
 type Event struct {
@@ -30,12 +32,18 @@ type Event struct {
 	Created      string
 	LastModified string  `vdir:"last-modified"`
 	Alarms       []Alarm `vdir:",object"`
-	RRule        RecurrenceRule
+	Duration     string
+	RRule        *Recurrence
+	RDate        []DateTimeList
+	ExDate       []DateTimeList
+	RecurrenceID DateTimeValue  `vdir:"recurrence-id,omitempty"`
+	Extra        []*ContentLine `vdir:",extra" json:",omitempty"`
 }
 
 type Person struct {
 	CommonName string `vdir:"cn"`
 	Url        string
+	Params     map[string]Value `vdir:",extra" json:",omitempty"`
 }
 
 type Alarm struct {
@@ -45,17 +53,25 @@ type Alarm struct {
 	Description string
 	Repeat      string
 	Duration    string
+	Extra       []*ContentLine `vdir:",extra" json:",omitempty"`
 }
 
 type Todo struct {
-	Profile  string `vdir:"vtodo,profile"`
-	DTStamp  DateTimeValue
-	Sequence string
-	UID      string
-	Due      string
-	Status   string
-	Summary  string
-	Alarms   []Alarm `vdir:",object"`
+	Profile      string `vdir:"vtodo,profile"`
+	DTStamp      DateTimeValue
+	Sequence     string
+	UID          string
+	DTStart      DateTimeValue `vdir:",omitempty"`
+	Due          DateTimeValue `vdir:",omitempty"`
+	Duration     string
+	Status       string
+	Summary      string
+	Alarms       []Alarm `vdir:",object"`
+	RRule        *Recurrence
+	RDate        []DateTimeList
+	ExDate       []DateTimeList
+	RecurrenceID DateTimeValue  `vdir:"recurrence-id,omitempty"`
+	Extra        []*ContentLine `vdir:",extra" json:",omitempty"`
 }
 
 type Journal struct {
@@ -67,6 +83,7 @@ type Journal struct {
 	Class       string
 	Categories  []string
 	Description string
+	Extra       []*ContentLine `vdir:",extra" json:",omitempty"`
 }
 
 type FreeBusy struct {
@@ -76,6 +93,7 @@ type FreeBusy struct {
 	DTEnd     DateTimeValue
 	FreeBusy  []string `vdir:",multiple"`
 	Url       string
+	Extra     []*ContentLine `vdir:",extra" json:",omitempty"`
 }
 
 type Timezone struct {
@@ -83,6 +101,7 @@ type Timezone struct {
 	TZId     string
 	Daylight []TimeZoneInfo `vdir:",object"`
 	Standard []TimeZoneInfo `vdir:",object"`
+	Extra    []*ContentLine `vdir:",extra" json:",omitempty"`
 }
 
 type TimeZoneInfo struct {
@@ -90,9 +109,14 @@ type TimeZoneInfo struct {
 	TZOffsetTo   string
 	TZName       string
 	DTStart      string
-	RRule        RecurrenceRule
+	RRule        *Recurrence
+	Extra        []*ContentLine `vdir:",extra" json:",omitempty"`
 }
 
+// RecurrenceRule splits an RRULE at its semicolons. It is the JSON form of
+// RRULE in earlier versions, which Recurrence still reads.
+//
+// Deprecated: Use Recurrence, which models every part of the rule.
 type RecurrenceRule struct {
 	Rule1 string
 	Rule2 string
@@ -106,3 +130,18 @@ type DateTimeValue struct {
 	Type  string `vdir:"value,param"`
 	Value string
 }
+
+// UnmarshalJSON implements json.Unmarshaler. It also accepts a string, the
+// JSON of values such as DUE that earlier versions kept as text.
+func (v *DateTimeValue) UnmarshalJSON(data []byte) error {
+	type dateTimeValue DateTimeValue
+	return unmarshalLegacyJSON(data, &v.Value, (*dateTimeValue)(v))
+}
+
+// DateTimeList is a list of dates, date-times or periods as in RDATE and
+// EXDATE.
+type DateTimeList struct {
+	TZId   string `vdir:",param"`
+	Type   string `vdir:"value,param"`
+	Values []string
+}
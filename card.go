@@ -1,8 +1,16 @@
 package golib_vcard
 
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+)
+
 type Card struct {
 	Profile       string `vdir:"vcard,profile"`
 	Version       string
+	Kind          string
 	FormattedName string `vdir:"fn"`
 	Name          Name   `vdir:"n"`
 	NickName      []string
@@ -15,17 +23,31 @@ type Card struct {
 	Url           []TypedValue
 	Related       []TypedValue
 	Cday          []Date
+	Gender        Gender `vdir:",omitempty"`
+	Member        []TypedValue
+	Lang          []TypedValue
+	TZ            []TypedValue
+	Geo           []Geo
 	Title         string
 	Role          string
-	Org           string
+	Org           Organization
 	Categories    []string
 	Note          string
-	URL           string
+	URL           string `vdir:"-"` // the first Url, in JSON only
 	Photo         Photo
+	Logo          Photo `vdir:",omitempty"`
+	Sound         Photo `vdir:",omitempty"`
+	Key           Photo `vdir:",omitempty"`
 
-	Rev    string
-	ProdId string
-	Uid    string
+	Rev          string
+	ProdId       string
+	Uid          string
+	Source       []TypedValue
+	ClientPIDMap []ClientPIDMap `vdir:"clientpidmap"`
+	XML          []string       `vdir:"xml,multiple"`
+	FBURL        []TypedValue
+	CalURI       []TypedValue
+	CalAdrURI    []TypedValue
 
 	XICQ    string `vdir:"x-icq"`
 	XSkype  string `vdir:"x-skype"`
@@ -33,17 +55,63 @@ type Card struct {
 	XJABBER string `vdir:"x-jabber"`
 	IMPP    []IMPP
 
-	Sensitivity string `vdir:sensitivity`
-	Folder      string `vdir:folder`
-	Gender      string `vdir:"x-wab-gender"`
+	Sensitivity string `vdir:"sensitivity"`
+	Folder      string `vdir:"folder"`
+	WabGender   string `vdir:"x-wab-gender"`
 	DisplayName string `vdir:"display-name"`
 	SelfURL     string `vdir:"selfurl"`
-	Starred     string `vdir:starred`
-	ShortName   string `vdir:short-name`
-	IsShare     string `vdir:isshare`
-	BrInterval  string `vdir:br-interval`
-	ArInterval  string `vdir:ar-interval`
-	IsRemind    string `vdir:isremind`
+	Starred     string `vdir:"starred"`
+	ShortName   string `vdir:"shortname"`
+	IsShare     string `vdir:"isshare"`
+	BrInterval  string `vdir:"brinterval"`
+	ArInterval  string `vdir:"arinterval"`
+	IsRemind    string `vdir:"isremind"`
+
+	Extra []*ContentLine `vdir:",extra" json:",omitempty"`
+}
+
+// MarshalJSON implements json.Marshaler. It sets URL to the first Url, which
+// holds the URL properties; URL itself is not mapped so that the property is
+// not read and written twice.
+func (c Card) MarshalJSON() ([]byte, error) {
+	type card Card
+	if c.URL == "" && len(c.Url) > 0 {
+		c.URL = c.Url[0].Value
+	}
+	return json.Marshal(card(c))
+}
+
+// UnmarshalJSON implements json.Unmarshaler. It also accepts the JSON of
+// earlier versions, where Gender was the string of X-WAB-GENDER and Org the
+// string of ORG, and a URL without Url.
+func (c *Card) UnmarshalJSON(data []byte) error {
+	type card Card
+	aux := struct {
+		*card
+		Gender json.RawMessage
+		Org    json.RawMessage
+	}{card: (*card)(c)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+	if err := unmarshalLegacyJSON(aux.Gender, &c.WabGender, &c.Gender); err != nil {
+		return err
+	}
+	if len(c.Url) == 0 && c.URL != "" {
+		c.Url = []TypedValue{{Value: c.URL}}
+	}
+	return unmarshalLegacyJSON(aux.Org, &c.Org.Name, &c.Org)
+}
+
+// unmarshalLegacyJSON decodes a JSON string into s and anything else into v.
+func unmarshalLegacyJSON(data json.RawMessage, s *string, v interface{}) error {
+	if len(data) == 0 {
+		return nil
+	}
+	if data[0] == '"' {
+		return json.Unmarshal(data, s)
+	}
+	return json.Unmarshal(data, v)
 }
 
 type IMPP struct {
@@ -71,6 +139,9 @@ type Photo struct {
 type Address struct {
 	Type            []string `vdir:",param"`
 	Label           string   `vdir:",param"`
+	Pref            string   `vdir:",param" json:",omitempty"`
+	AltID           string   `vdir:",param" json:",omitempty"`
+	PID             []string `vdir:",param" json:",omitempty"`
 	PostOfficeBox   string
 	ExtendedAddress string
 	Street          string
@@ -78,6 +149,9 @@ type Address struct {
 	Region          string
 	PostalCode      string
 	CountryName     string
+	Group           string           `vdir:",group" json:",omitempty"`
+	CustomLabel     string           `vdir:"x-ablabel,grouped" json:",omitempty"`
+	Params          map[string]Value `vdir:",extra" json:",omitempty"`
 }
 
 type Date struct {
@@ -90,6 +164,105 @@ type Date struct {
 }
 
 type TypedValue struct {
-	Type  []string `vdir:",param"`
-	Value string
+	Type      []string `vdir:",param"`
+	Pref      string   `vdir:",param" json:",omitempty"`
+	AltID     string   `vdir:",param" json:",omitempty"`
+	PID       []string `vdir:",param" json:",omitempty"`
+	MediaType string   `vdir:",param" json:",omitempty"`
+	Value     string
+	Group     string           `vdir:",group" json:",omitempty"`
+	Label     string           `vdir:"x-ablabel,grouped" json:",omitempty"`
+	Params    map[string]Value `vdir:",extra" json:",omitempty"`
+}
+
+type Gender struct {
+	Sex      string
+	Identity string
+}
+
+type ClientPIDMap struct {
+	SourceID string
+	URI      string
+}
+
+// Organization is the structured ORG value of an organization name followed
+// by any number of organizational units.
+type Organization struct {
+	Name   string
+	Units  []string
+	Params map[string]Value `json:",omitempty"`
+}
+
+// MarshalVdir implements Marshaler.
+func (o Organization) MarshalVdir() (*ContentLine, error) {
+	if o.Name == "" && len(o.Units) == 0 {
+		return nil, nil
+	}
+	cl := &ContentLine{Params: o.Params, Value: StructuredValue{Value{o.Name}}}
+	for _, u := range o.Units {
+		cl.Value = append(cl.Value, Value{u})
+	}
+	return cl, nil
+}
+
+// UnmarshalVdir implements Unmarshaler. Commas in a component are kept as
+// part of the name.
+func (o *Organization) UnmarshalVdir(cl *ContentLine) error {
+	*o = Organization{Params: cl.Params}
+	for i, v := range cl.Value {
+		if i == 0 {
+			o.Name = strings.Join(v, ",")
+		} else {
+			o.Units = append(o.Units, strings.Join(v, ","))
+		}
+	}
+	return nil
+}
+
+// Geo is a GEO position. It is written as a vCard 4.0 geo: URI unless Legacy
+// is set; Encoder.Version rewrites it as needed.
+type Geo struct {
+	Latitude  float64
+	Longitude float64
+	Params    map[string]Value `json:",omitempty"`
+
+	// Legacy selects the "latitude;longitude" form of vCard 3.0 and
+	// earlier. It is set for a value read in that form.
+	Legacy bool `json:",omitempty"`
+}
+
+// MarshalVdir implements Marshaler.
+func (g Geo) MarshalVdir() (*ContentLine, error) {
+	lat := strconv.FormatFloat(g.Latitude, 'f', -1, 64)
+	lon := strconv.FormatFloat(g.Longitude, 'f', -1, 64)
+	if g.Legacy {
+		return &ContentLine{Params: g.Params, Value: StructuredValue{Value{lat}, Value{lon}}}, nil
+	}
+	return &ContentLine{Params: g.Params, Value: StructuredValue{Value{"geo:" + lat + "," + lon}}}, nil
+}
+
+// UnmarshalVdir implements Unmarshaler. It accepts the geo: URI of vCard 4.0
+// and the "latitude;longitude" form of earlier versions.
+func (g *Geo) UnmarshalVdir(cl *ContentLine) error {
+	lat, lon := "", ""
+	if v := cl.Value.GetText(); strings.HasPrefix(strings.ToLower(v), "geo:") {
+		coords := strings.SplitN(strings.SplitN(v[len("geo:"):], ";", 2)[0], ",", 3)
+		if len(coords) < 2 {
+			return errors.New("Malformed GEO value " + strconv.Quote(v))
+		}
+		lat, lon = coords[0], coords[1]
+	} else if len(cl.Value) == 2 {
+		lat, lon = cl.Value[0].GetText(), cl.Value[1].GetText()
+	} else {
+		return errors.New("Malformed GEO value " + strconv.Quote(v))
+	}
+	var err error
+	*g = Geo{Params: cl.Params, Legacy: len(cl.Value) == 2}
+	if g.Latitude, err = strconv.ParseFloat(strings.TrimSpace(lat), 64); err != nil {
+		return errors.New("Malformed GEO latitude " + strconv.Quote(lat))
+	}
+	if g.Longitude, err = strconv.ParseFloat(strings.TrimSpace(lon), 64); err != nil {
+		return errors.New("Malformed GEO longitude " + strconv.Quote(lon))
+	}
+	return nil
 }
@@ -0,0 +1,232 @@
+package golib_vcard
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// rfc6350Author is the example of RFC 6350 §8.
+const rfc6350Author = "BEGIN:VCARD\r\n" +
+	"VERSION:4.0\r\n" +
+	"FN:Simon Perreault\r\n" +
+	"N:Perreault;Simon;;;ing. jr,M.Sc.\r\n" +
+	"BDAY:--0203\r\n" +
+	"ANNIVERSARY:20090808T1430-0500\r\n" +
+	"GENDER:M\r\n" +
+	"LANG;PREF=1:fr\r\n" +
+	"LANG;PREF=2:en\r\n" +
+	"ORG;TYPE=work:Viagenie\r\n" +
+	"ADR;TYPE=work:;Suite D2-630;2875 Laurier;\r\n" +
+	" Quebec;QC;G1V 2M2;Canada\r\n" +
+	"TEL;VALUE=uri;TYPE=\"work,voice\";PREF=1:tel:+1-418-656-9254;ext=102\r\n" +
+	"TEL;VALUE=uri;TYPE=\"work,cell,voice,video,text\":tel:+1-418-262-6501\r\n" +
+	"EMAIL;TYPE=work:simon.perreault@viagenie.ca\r\n" +
+	"GEO;TYPE=work:geo:46.772673,-71.282945\r\n" +
+	"KEY;TYPE=work;VALUE=uri:\r\n" +
+	" http://www.viagenie.ca/simon.perreault/simon.asc\r\n" +
+	"TZ:-0500\r\n" +
+	"URL;TYPE=home:http://nomis80.org\r\n" +
+	"END:VCARD\r\n"
+
+// rfc6350Sync is the merged card of RFC 6350 §7.2.4, with ALTID from §5.4.
+const rfc6350Sync = "BEGIN:VCARD\r\n" +
+	"VERSION:4.0\r\n" +
+	"KIND:individual\r\n" +
+	"UID:urn:uuid:4fbe8971-0bc3-424c-9c26-36c3e1eff6b1\r\n" +
+	"FN;PID=1.1:J. Doe\r\n" +
+	"N:Doe;J.;;;\r\n" +
+	"EMAIL;PID=1.1:jdoe@example.com\r\n" +
+	"EMAIL;PID=2.1:boss@example.com\r\n" +
+	"EMAIL;PID=2.2:ceo@example.com\r\n" +
+	"TEL;PID=1.1;VALUE=uri:tel:+1-555-555-5555\r\n" +
+	"TEL;PID=2.1,2.2;VALUE=uri:tel:+1-666-666-6666\r\n" +
+	"URL;ALTID=1;LANGUAGE=fr:http://example.com/fr\r\n" +
+	"URL;ALTID=1;LANGUAGE=en:http://example.com/en\r\n" +
+	"CLIENTPIDMAP:1;urn:uuid:3df403f4-5924-4bb7-b077-3c711d9eb34b\r\n" +
+	"CLIENTPIDMAP:2;urn:uuid:d89c9c7a-2e1b-4832-82de-7e992d95faa5\r\n" +
+	"END:VCARD\r\n"
+
+func TestCardRFC6350Author(t *testing.T) {
+	var c Card
+	if err := Unmarshal([]byte(rfc6350Author), &c); err != nil {
+		t.Fatal(err)
+	}
+	if c.Gender != (Gender{Sex: "M"}) {
+		t.Errorf("got Gender %+v", c.Gender)
+	}
+	if len(c.Lang) != 2 || c.Lang[0].Pref != "1" || c.Lang[0].Value != "fr" || c.Lang[1].Pref != "2" || c.Lang[1].Value != "en" {
+		t.Errorf("got Lang %+v", c.Lang)
+	}
+	if c.Org.Name != "Viagenie" || len(c.Org.Units) != 0 || c.Org.Params["TYPE"].GetText() != "work" {
+		t.Errorf("got Org %+v", c.Org)
+	}
+	if len(c.Addresses) != 1 || c.Addresses[0].Locality != "Quebec" || c.Addresses[0].CountryName != "Canada" {
+		t.Errorf("got Addresses %+v", c.Addresses)
+	}
+	if len(c.Telephones) != 2 || c.Telephones[0].Pref != "1" || c.Telephones[0].Value != "tel:+1-418-656-9254;ext=102" || c.Telephones[1].Pref != "" {
+		t.Errorf("got Telephones %+v", c.Telephones)
+	}
+	if len(c.Geo) != 1 || c.Geo[0].Latitude != 46.772673 || c.Geo[0].Longitude != -71.282945 {
+		t.Errorf("got Geo %+v", c.Geo)
+	}
+	if c.Key.Value != "uri" || c.Key.Data != "http://www.viagenie.ca/simon.perreault/simon.asc" {
+		t.Errorf("got Key %+v", c.Key)
+	}
+	if c.Anniversary != "20090808T1430-0500" || len(c.TZ) != 1 || c.TZ[0].Value != "-0500" {
+		t.Errorf("got Anniversary %q, TZ %+v", c.Anniversary, c.TZ)
+	}
+}
+
+func TestCardRFC6350Sync(t *testing.T) {
+	var c Card
+	if err := Unmarshal([]byte(rfc6350Sync), &c); err != nil {
+		t.Fatal(err)
+	}
+	if c.Kind != "individual" {
+		t.Errorf("got Kind %q", c.Kind)
+	}
+	var pids []string
+	for _, e := range c.Email {
+		pids = append(pids, strings.Join(e.PID, ","))
+	}
+	if strings.Join(pids, "|") != "1.1|2.1|2.2" {
+		t.Errorf("got EMAIL PIDs %q", pids)
+	}
+	if len(c.Telephones) != 2 || !reflect.DeepEqual(c.Telephones[1].PID, []string{"2.1", "2.2"}) {
+		t.Errorf("got Telephones %+v", c.Telephones)
+	}
+	if len(c.Url) != 2 || c.Url[0].AltID != "1" || c.Url[1].AltID != "1" || c.Url[1].Params["LANGUAGE"].GetText() != "en" {
+		t.Errorf("got Url %+v", c.Url)
+	}
+	want := []ClientPIDMap{
+		{"1", "urn:uuid:3df403f4-5924-4bb7-b077-3c711d9eb34b"},
+		{"2", "urn:uuid:d89c9c7a-2e1b-4832-82de-7e992d95faa5"},
+	}
+	if !reflect.DeepEqual(c.ClientPIDMap, want) {
+		t.Errorf("got ClientPIDMap %+v", c.ClientPIDMap)
+	}
+}
+
+func TestCardRoundTrip(t *testing.T) {
+	for _, in := range []string{rfc6350Author, rfc6350Sync} {
+		var want Card
+		if err := Unmarshal([]byte(in), &want); err != nil {
+			t.Fatal(err)
+		}
+		out, err := Marshal(&want)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n := strings.Count(string(out), "\r\nURL"); n != len(want.Url) {
+			t.Errorf("got %d URL lines, want %d", n, len(want.Url))
+		}
+		var got Card
+		if err := Unmarshal(out, &got); err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %+v, want %+v", got, want)
+		}
+
+		b, err := json.Marshal(&want)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = Card{}
+		if err := json.Unmarshal(b, &got); err != nil {
+			t.Fatal(err)
+		}
+		if got.Gender != want.Gender || !reflect.DeepEqual(got.Org, want.Org) || !reflect.DeepEqual(got.Email, want.Email) || !reflect.DeepEqual(got.Url, want.Url) {
+			t.Errorf("JSON round trip got %+v, want %+v", got, want)
+		}
+		if got.URL != want.Url[0].Value {
+			t.Errorf("got URL %q, want %q", got.URL, want.Url[0].Value)
+		}
+	}
+}
+
+func TestCardLegacyJSON(t *testing.T) {
+	tests := []struct {
+		name, in string
+		want     []string
+	}{
+		{"strings", `{"Gender":"male","Org":"Acme"}`, []string{"X-WAB-GENDER:male\r\n", "ORG:Acme\r\n"}},
+		{"objects", `{"Gender":{"Sex":"F","Identity":"woman"},"Org":{"Name":"Acme","Units":["Sales"]}}`, []string{"GENDER:F;woman\r\n", "ORG:Acme;Sales\r\n"}},
+		{"null", `{"Gender":null,"Org":null,"FormattedName":"A"}`, []string{"FN:A\r\n"}},
+		{"url", `{"URL":"http://example.com"}`, []string{"URL:http://example.com\r\n"}},
+	}
+	for _, test := range tests {
+		out := JsonToVcard(test.in)
+		for _, line := range test.want {
+			if !strings.Contains(out, line) {
+				t.Errorf("%s: got %q, want line %q", test.name, out, line)
+			}
+		}
+	}
+
+	out, err := ConvertJsonToVcard([]byte(`{"gender":"male","org":"Acme"}`), &JsonOptions{FieldNaming: SnakeCase})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(out), "X-WAB-GENDER:male\r\n") || !strings.Contains(string(out), "ORG:Acme\r\n") {
+		t.Errorf("SnakeCase: got %q", out)
+	}
+
+	var c Card
+	if err := json.Unmarshal([]byte(`{"Gender":1}`), &c); err == nil {
+		t.Error("got no error for a number Gender")
+	}
+}
+
+func TestCardVendorPropertyNames(t *testing.T) {
+	c := Card{Sensitivity: "1", Folder: "2", Starred: "3", ShortName: "4", IsShare: "5", BrInterval: "6", ArInterval: "7", IsRemind: "8"}
+	out, err := Marshal(&c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"SENSITIVITY:1", "FOLDER:2", "STARRED:3", "SHORTNAME:4", "ISSHARE:5", "BRINTERVAL:6", "ARINTERVAL:7", "ISREMIND:8"} {
+		if !strings.Contains(string(out), "\r\n"+line+"\r\n") {
+			t.Errorf("got %q, want line %q", out, line)
+		}
+	}
+	var got Card
+	if err := Unmarshal(out, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.ShortName != "4" || got.ArInterval != "7" {
+		t.Errorf("got %+v", got)
+	}
+
+	out, err = Marshal(&BJSON{SMSDATA: []string{"a", "b"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(out), "\r\nSMSDATA:a,b\r\n") {
+		t.Errorf("got %q", out)
+	}
+}
+
+func TestCardVersion3Values(t *testing.T) {
+	const in = "BEGIN:VCARD\r\nVERSION:3.0\r\nFN:Alice\r\n" +
+		"TEL;TYPE=HOME;PREF=x:+1 555\r\n" +
+		"EMAIL;PREF=yes:alice@example.com\r\n" +
+		"GEO:37.386013;-122.082932\r\n" +
+		"END:VCARD\r\n"
+	var c Card
+	if err := Unmarshal([]byte(in), &c); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Telephones) != 1 || c.Telephones[0].Pref != "x" || len(c.Email) != 1 || c.Email[0].Pref != "yes" {
+		t.Errorf("got Telephones %+v, Email %+v", c.Telephones, c.Email)
+	}
+
+	// the form of GEO survives the JSON conversions
+	out := JsonToVcard(VcardToJson(in))
+	for _, line := range []string{"GEO:37.386013;-122.082932\r\n", "TEL;TYPE=HOME;PREF=x:+1 555\r\n"} {
+		if !strings.Contains(out, line) {
+			t.Errorf("got %q, want line %q", out, line)
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package golib_vcard
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"strings"
+	"unicode/utf8"
+)
+
+// paramValue returns the first value of the named parameter, matching the
+// name case-insensitively.
+func paramValue(params map[string]Value, name string) (key, value string) {
+	if v, ok := params[name]; ok {
+		return name, v.GetText()
+	}
+	for k, v := range params {
+		if strings.EqualFold(k, name) {
+			return k, v.GetText()
+		}
+	}
+	return "", ""
+}
+
+// decodeValues parses the raw value of a content line and resolves the vCard
+// 2.1 quoted-printable transfer encoding, transcoding the decoded bytes from
+// their CHARSET to UTF-8. Unencoded values in a charset other than UTF-8 are
+// transcoded as well. The resolved ENCODING and CHARSET parameters are removed
+// from params. A value whose charset cannot be converted is kept undecoded,
+// with both parameters, and the problem is recorded in Errors.
+func (dec *Decoder) decodeValues(name string, params map[string]Value, raw string) (StructuredValue, error) {
+	encKey, enc := paramValue(params, "ENCODING")
+	csKey, charset := paramValue(params, "CHARSET")
+	qp := strings.EqualFold(enc, "QUOTED-PRINTABLE")
+	parse := parseValues
+	if isURIValue(name, params, raw) {
+		parse = parseURIValue
+	}
+	if !qp && (charset == "" || isUTF8(charset)) {
+		if charset != "" {
+			delete(params, csKey)
+		}
+		return parse(name, raw, nil)
+	}
+
+	if e := strings.ToUpper(enc); !qp && (e == "B" || e == "BASE64") {
+		// binary data has no charset to convert
+		return parse(name, raw, nil)
+	}
+	value, err := parse(name, raw, func(s string) (string, error) {
+		if !qp {
+			return dec.transcode(charset, []byte(s))
+		}
+		return dec.transcode(charset, decodeQuotedPrintable(s))
+	})
+	if _, ok := err.(*CharsetError); ok {
+		dec.errs = append(dec.errs, dec.wrapError(dec.pos, err))
+		return parse(name, raw, nil)
+	}
+	if err != nil {
+		return nil, dec.wrapError(dec.pos, err)
+	}
+	if qp {
+		delete(params, encKey)
+	}
+	delete(params, csKey)
+	return value, nil
+}
+
+// A CharsetError reports a value that was kept undecoded because its charset
+// is not supported.
+type CharsetError struct {
+	Charset string
+	Err     error // the error of the CharsetReader, or nil if there is none
+}
+
+func (e *CharsetError) Error() string {
+	if e.Err != nil {
+		return "cannot convert charset " + e.Charset + ": " + e.Err.Error()
+	}
+	return "unsupported charset " + e.Charset + ", set Decoder.CharsetReader or DefaultCharsetReader"
+}
+
+// transcode converts b from charset to a UTF-8 string.
+func (dec *Decoder) transcode(charset string, b []byte) (string, error) {
+	if isASCII(b) {
+		return string(b), nil
+	}
+	switch strings.ToUpper(charset) {
+	case "", "UTF-8", "UTF8", "US-ASCII", "ASCII":
+		if !utf8.Valid(b) {
+			return "", errors.New("invalid " + charset + " text in value")
+		}
+		return string(b), nil
+	case "ISO-8859-1", "LATIN1", "ISO_8859-1":
+		r := make([]rune, len(b))
+		for i, c := range b {
+			r[i] = rune(c)
+		}
+		return string(r), nil
+	}
+	if dec.CharsetReader == nil {
+		return "", &CharsetError{Charset: charset}
+	}
+	r, err := dec.CharsetReader(charset, bytes.NewReader(b))
+	if err != nil {
+		return "", &CharsetError{Charset: charset, Err: err}
+	}
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		return "", err
+	}
+	return string(out), nil
+}
+
+func isASCII(b []byte) bool {
+	for _, c := range b {
+		if c >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
+
+func isUTF8(charset string) bool {
+	return strings.EqualFold(charset, "UTF-8") || strings.EqualFold(charset, "UTF8")
+}
+
+// decodeQuotedPrintable decodes =XX escapes. Malformed escapes are kept
+// literally, as many phone exports produce them.
+func decodeQuotedPrintable(s string) []byte {
+	b := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] == '=' && i+2 < len(s) && isHex(s[i+1]) && isHex(s[i+2]) {
+			b = append(b, unhex(s[i+1])<<4|unhex(s[i+2]))
+			i += 2
+			continue
+		}
+		b = append(b, s[i])
+	}
+	return b
+}
+
+func isHex(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+func unhex(c byte) byte {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0'
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10
+	}
+	return c - 'A' + 10
+}
@@ -0,0 +1,118 @@
+package golib_vcard
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// gbkReader stands in for a real GBK decoder such as the one in
+// golang.org/x/text/encoding/simplifiedchinese; it knows only "张三".
+func gbkReader(charset string, input io.Reader) (io.Reader, error) {
+	if !strings.EqualFold(charset, "GBK") {
+		return nil, errors.New("unexpected charset " + charset)
+	}
+	b, err := ioutil.ReadAll(input)
+	if err != nil {
+		return nil, err
+	}
+	b = bytes.Replace(b, []byte("\xd5\xc5"), []byte("张"), -1)
+	b = bytes.Replace(b, []byte("\xc8\xfd"), []byte("三"), -1)
+	return bytes.NewReader(b), nil
+}
+
+const gbkCard = "BEGIN:VCARD\r\nVERSION:2.1\r\nN;CHARSET=GBK;ENCODING=QUOTED-PRINTABLE:=D5=C5;=C8=FD;;;\r\nFN;CHARSET=GBK;ENCODING=QUOTED-PRINTABLE:=D5=C5=C8=FD\r\nEND:VCARD\r\n"
+
+func TestDecodeQuotedPrintable(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{"utf-8", "FN;CHARSET=UTF-8;ENCODING=QUOTED-PRINTABLE:=E5=BC=A0=E4=B8=89", "张三"},
+		{"soft line break", "FN;CHARSET=UTF-8;ENCODING=QUOTED-PRINTABLE:=E5=BC=\r\n=A0=E4=B8=89", "张三"},
+		{"latin-1", "FN;CHARSET=ISO-8859-1;ENCODING=QUOTED-PRINTABLE:Andr=E9", "André"},
+		{"malformed escape", "FN;ENCODING=QUOTED-PRINTABLE:1=3D1=ZZ", "1=1=ZZ"},
+	}
+	for _, test := range tests {
+		var c Card
+		err := Unmarshal([]byte("BEGIN:VCARD\r\nVERSION:2.1\r\n"+test.in+"\r\nEND:VCARD\r\n"), &c)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if c.FormattedName != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, c.FormattedName, test.want)
+		}
+	}
+}
+
+func TestDecodeUnsupportedCharset(t *testing.T) {
+	var c Card
+	if err := Unmarshal([]byte(gbkCard), &c); err != nil {
+		t.Fatal(err)
+	}
+	if c.FormattedName != "=D5=C5=C8=FD" {
+		t.Errorf("got FN %q, want the undecoded value", c.FormattedName)
+	}
+	if out := VcardToJson(gbkCard); !strings.Contains(out, `"FormattedName":"=D5=C5=C8=FD"`) {
+		t.Errorf("VcardToJson got %s", out)
+	}
+
+	tests := []struct {
+		name   string
+		reader func(string, io.Reader) (io.Reader, error)
+	}{
+		{"no reader", nil},
+		{"reader error", func(charset string, input io.Reader) (io.Reader, error) {
+			return nil, errors.New("unknown charset")
+		}},
+	}
+	for _, test := range tests {
+		dec := NewDecoder(strings.NewReader(gbkCard))
+		dec.CharsetReader = test.reader
+		o, err := dec.ReadObject()
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if len(dec.Errors()) != 2 {
+			t.Fatalf("%s: got errors %v, want 2", test.name, dec.Errors())
+		}
+		var ce *CharsetError
+		if !errors.As(dec.Errors()[0], &ce) || ce.Charset != "GBK" || (ce.Err != nil) != (test.reader != nil) {
+			t.Errorf("%s: got error %v", test.name, dec.Errors()[0])
+		}
+
+		// the undecoded value is written back unchanged
+		var b bytes.Buffer
+		if err := NewEncoder(&b).WriteObject(o); err != nil {
+			t.Fatal(err)
+		}
+		if b.String() != gbkCard {
+			t.Errorf("%s: got %q, want %q", test.name, b.String(), gbkCard)
+		}
+	}
+}
+
+func TestDecodeCharsetReader(t *testing.T) {
+	dec := NewDecoder(strings.NewReader(gbkCard))
+	dec.CharsetReader = gbkReader
+	var c Card
+	if err := dec.Decode(&c); err != nil {
+		t.Fatal(err)
+	}
+	if c.FormattedName != "张三" || len(c.Name.FamilyName) != 1 || c.Name.FamilyName[0] != "张" {
+		t.Errorf("got FN %q, N %q", c.FormattedName, c.Name.FamilyName)
+	}
+
+	DefaultCharsetReader = gbkReader
+	defer func() { DefaultCharsetReader = nil }()
+	c = Card{}
+	if err := Unmarshal([]byte(gbkCard), &c); err != nil {
+		t.Fatal(err)
+	}
+	if c.FormattedName != "张三" {
+		t.Errorf("got FN %q with DefaultCharsetReader", c.FormattedName)
+	}
+}
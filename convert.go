@@ -0,0 +1,373 @@
+package golib_vcard
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"unicode"
+)
+
+// ErrorKind classifies the failures reported by a ConvertError.
+type ErrorKind int
+
+const (
+	// KindSyntax reports malformed or empty directory text.
+	KindSyntax ErrorKind = iota + 1
+	// KindMapping reports an object that could not be mapped to or from
+	// the Go value, for example a VCALENDAR passed where a VCARD is expected.
+	KindMapping
+	// KindJson reports malformed JSON input or a JSON encoding failure.
+	KindJson
+)
+
+func (k ErrorKind) String() string {
+	switch k {
+	case KindSyntax:
+		return "syntax error"
+	case KindMapping:
+		return "mapping error"
+	case KindJson:
+		return "json error"
+	}
+	return "unknown error"
+}
+
+// ConvertError is returned by the Convert* functions and describes which
+// stage of a conversion failed.
+type ConvertError struct {
+	Kind ErrorKind
+	Err  error
+}
+
+func (e *ConvertError) Error() string {
+	return e.Kind.String() + ": " + e.Err.Error()
+}
+
+// Unwrap returns the underlying error.
+func (e *ConvertError) Unwrap() error {
+	return e.Err
+}
+
+// JsonOptions controls the JSON produced and accepted by the Convert*
+// functions. A nil *JsonOptions is equivalent to the zero value, which
+// produces the same compact output as json.Marshal.
+type JsonOptions struct {
+	// Prefix and Indent are passed to json.MarshalIndent if Indent is set.
+	Prefix string
+	Indent string
+
+	// FieldNaming maps Go struct field names to JSON keys. It is applied
+	// when encoding and reversed when decoding. A nil FieldNaming keeps the
+	// Go field names.
+	//
+	// LowerCamelCase and SnakeCase are provided; any other function works as
+	// long as it is deterministic.
+	FieldNaming func(string) string
+}
+
+// LowerCamelCase is a FieldNaming that turns "FormattedName" into
+// "formattedName" and "UID" into "uid".
+func LowerCamelCase(name string) string {
+	r := []rune(name)
+	for i := 0; i < len(r) && unicode.IsUpper(r[i]); i++ {
+		if i > 0 && i+1 < len(r) && unicode.IsLower(r[i+1]) {
+			break
+		}
+		r[i] = unicode.ToLower(r[i])
+	}
+	return string(r)
+}
+
+// SnakeCase is a FieldNaming that turns "FormattedName" into
+// "formatted_name" and "DTStart" into "dt_start".
+func SnakeCase(name string) string {
+	r := []rune(name)
+	var buf []rune
+	for i, c := range r {
+		if unicode.IsUpper(c) {
+			if i > 0 && (unicode.IsLower(r[i-1]) || i+1 < len(r) && unicode.IsLower(r[i+1])) {
+				buf = append(buf, '_')
+			}
+			c = unicode.ToLower(c)
+		}
+		buf = append(buf, c)
+	}
+	return string(buf)
+}
+
+func (opts *JsonOptions) marshal(v interface{}) ([]byte, error) {
+	if opts == nil {
+		opts = &JsonOptions{}
+	}
+	var d interface{} = v
+	if opts.FieldNaming != nil {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		var generic interface{}
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.UseNumber()
+		if err := dec.Decode(&generic); err != nil {
+			return nil, err
+		}
+		d = renameKeys(generic, reflect.TypeOf(v), opts.FieldNaming, false)
+	}
+	if opts.Indent != "" {
+		return json.MarshalIndent(d, opts.Prefix, opts.Indent)
+	}
+	return json.Marshal(d)
+}
+
+func (opts *JsonOptions) unmarshal(data []byte, v interface{}) error {
+	if opts == nil || opts.FieldNaming == nil {
+		return json.Unmarshal(data, v)
+	}
+	var generic interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&generic); err != nil {
+		return err
+	}
+	b, err := json.Marshal(renameKeys(generic, reflect.TypeOf(v), opts.FieldNaming, true))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// orderedObject is a JSON object that keeps the struct field order when
+// encoded.
+type orderedObject []orderedField
+
+type orderedField struct {
+	key   string
+	value interface{}
+}
+
+func (o orderedObject) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, f := range o {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		k, err := json.Marshal(f.key)
+		if err != nil {
+			return nil, err
+		}
+		b.Write(k)
+		b.WriteByte(':')
+		v, err := json.Marshal(f.value)
+		if err != nil {
+			return nil, err
+		}
+		b.Write(v)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+// renameKeys walks a generic JSON value alongside the Go type it was
+// produced from (or is destined for) and renames the struct keys with
+// naming. If reverse is set, named keys are mapped back to field names.
+func renameKeys(v interface{}, typ reflect.Type, naming func(string) string, reverse bool) interface{} {
+	for typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	switch val := v.(type) {
+	case map[string]interface{}:
+		switch typ.Kind() {
+		case reflect.Struct:
+			fields := jsonFields(typ)
+			keys := namedKeys(fields, naming)
+			if reverse {
+				names := make(map[string]int, len(keys))
+				for i, k := range keys {
+					names[k] = i
+				}
+				out := make(map[string]interface{}, len(val))
+				for k, vv := range val {
+					if i, ok := names[k]; ok {
+						out[fields[i].name] = renameKeys(vv, fields[i].typ, naming, reverse)
+					} else {
+						out[k] = vv
+					}
+				}
+				return out
+			}
+			var out orderedObject
+			for i, f := range fields {
+				if vv, ok := val[f.name]; ok {
+					out = append(out, orderedField{keys[i], renameKeys(vv, f.typ, naming, reverse)})
+				}
+			}
+			return out
+		case reflect.Map:
+			out := make(map[string]interface{}, len(val))
+			for k, vv := range val {
+				out[k] = renameKeys(vv, typ.Elem(), naming, reverse)
+			}
+			return out
+		}
+	case []interface{}:
+		if typ.Kind() == reflect.Slice || typ.Kind() == reflect.Array {
+			out := make([]interface{}, len(val))
+			for i, vv := range val {
+				out[i] = renameKeys(vv, typ.Elem(), naming, reverse)
+			}
+			return out
+		}
+	}
+	return v
+}
+
+// jsonField is a field of a struct as encoding/json sees it.
+type jsonField struct {
+	name   string // the JSON key
+	tagged bool   // name is given by the json tag
+	typ    reflect.Type
+}
+
+// jsonFields lists the fields encoding/json uses for the struct type typ, in
+// order: exported fields not tagged "-", and the fields of untagged embedded
+// structs. Of several fields with the same key, the least nested one wins;
+// at the same depth, the only tagged one wins or else all of them are
+// dropped.
+func jsonFields(typ reflect.Type) []jsonField {
+	type entry struct {
+		jsonField
+		depth int
+	}
+	var entries []entry
+	var walk func(typ reflect.Type, depth int, visited map[reflect.Type]bool)
+	walk = func(typ reflect.Type, depth int, visited map[reflect.Type]bool) {
+		for i := 0; i < typ.NumField(); i++ {
+			sf := typ.Field(i)
+			tag := sf.Tag.Get("json")
+			if tag == "-" {
+				continue
+			}
+			name := strings.Split(tag, ",")[0]
+			if sf.Anonymous {
+				et := sf.Type
+				if et.Kind() == reflect.Ptr {
+					et = et.Elem()
+				}
+				if sf.PkgPath != "" && et.Kind() != reflect.Struct {
+					continue
+				}
+				if name == "" && et.Kind() == reflect.Struct {
+					if !visited[et] {
+						visited[et] = true
+						walk(et, depth+1, visited)
+						delete(visited, et)
+					}
+					continue
+				}
+			} else if sf.PkgPath != "" {
+				continue
+			}
+			f := jsonField{name, name != "", sf.Type}
+			if name == "" {
+				f.name = sf.Name
+			}
+			entries = append(entries, entry{f, depth})
+		}
+	}
+	walk(typ, 0, map[reflect.Type]bool{typ: true})
+
+	byName := make(map[string][]int)
+	for i, e := range entries {
+		byName[e.name] = append(byName[e.name], i)
+	}
+	// dominant returns the entry that wins among s, or -1
+	dominant := func(s []int) int {
+		var shallowest []int
+		for _, j := range s {
+			if len(shallowest) == 0 || entries[j].depth < entries[shallowest[0]].depth {
+				shallowest = []int{j}
+			} else if entries[j].depth == entries[shallowest[0]].depth {
+				shallowest = append(shallowest, j)
+			}
+		}
+		if len(shallowest) == 1 {
+			return shallowest[0]
+		}
+		winner := -1
+		for _, j := range shallowest {
+			if entries[j].tagged {
+				if winner >= 0 {
+					return -1
+				}
+				winner = j
+			}
+		}
+		return winner
+	}
+	var fields []jsonField
+	for i, e := range entries {
+		if dominant(byName[e.name]) == i {
+			fields = append(fields, e.jsonField)
+		}
+	}
+	return fields
+}
+
+// namedKeys returns the named JSON key of every field. A field whose named
+// key collides with an earlier one (Card has both Url and URL) keeps its
+// JSON key.
+func namedKeys(fields []jsonField, naming func(string) string) []string {
+	keys := make([]string, len(fields))
+	seen := make(map[string]bool)
+	for i, f := range fields {
+		keys[i] = naming(f.name)
+		if seen[keys[i]] {
+			keys[i] = f.name
+		}
+		seen[keys[i]] = true
+	}
+	return keys
+}
+
+// decodeToJson reads a single object of the given profile from data, maps it
+// into v and returns the JSON encoding of v. If the object cannot be read or
+// mapped, it returns the JSON of what was mapped into v with the error.
+func decodeToJson(data []byte, v interface{}, profile string, opts *JsonOptions) ([]byte, error) {
+	var cerr *ConvertError
+	o, err := NewDecoder(bytes.NewReader(data)).ReadObject()
+	switch {
+	case err != nil:
+		cerr = &ConvertError{KindSyntax, err}
+	case !strings.EqualFold(o.Profile, profile):
+		cerr = &ConvertError{KindMapping, errors.New("Expected " + profile + " object, found " + o.Profile)}
+	default:
+		if err := FromObject(v, o); err != nil {
+			cerr = &ConvertError{KindMapping, err}
+		}
+	}
+	b, err := opts.marshal(v)
+	if err != nil {
+		return nil, &ConvertError{KindJson, err}
+	}
+	if cerr != nil {
+		return b, cerr
+	}
+	return b, nil
+}
+
+// encodeFromJson decodes the JSON data into v and returns its directory
+// encoding. If v cannot be encoded, it returns what was written with the
+// error.
+func encodeFromJson(data []byte, v interface{}, opts *JsonOptions) ([]byte, error) {
+	if err := opts.unmarshal(data, v); err != nil {
+		return nil, &ConvertError{KindJson, err}
+	}
+	b, err := Marshal(v)
+	if err != nil {
+		return b, &ConvertError{KindMapping, err}
+	}
+	return b, nil
+}
@@ -0,0 +1,149 @@
+package golib_vcard
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestConvertErrorKinds(t *testing.T) {
+	tests := []struct {
+		name string
+		conv func() error
+		kind ErrorKind
+	}{
+		{"syntax", func() error {
+			_, err := ConvertVcardToJson([]byte("BEGIN:VCARD\r\nFN Alice\r\nEND:VCARD\r\n"), nil)
+			return err
+		}, KindSyntax},
+		{"mapping", func() error {
+			_, err := ConvertVcardToJson([]byte("BEGIN:VCALENDAR\r\nVERSION:2.0\r\nEND:VCALENDAR\r\n"), nil)
+			return err
+		}, KindMapping},
+		{"json", func() error {
+			_, err := ConvertJsonToVcard([]byte(`{"FormattedName":`), nil)
+			return err
+		}, KindJson},
+	}
+	for _, test := range tests {
+		err := test.conv()
+		var ce *ConvertError
+		if !errors.As(err, &ce) {
+			t.Errorf("%s: got error %v, want *ConvertError", test.name, err)
+			continue
+		}
+		if ce.Kind != test.kind {
+			t.Errorf("%s: got kind %v, want %v", test.name, ce.Kind, test.kind)
+		}
+	}
+}
+
+func TestLegacyWrappersOnError(t *testing.T) {
+	emptyCard, _ := json.Marshal(Card{})
+	emptyCalendar, _ := json.Marshal(Calendar{})
+	if got := VcardToJson("not a vcard"); got != string(emptyCard) {
+		t.Errorf("VcardToJson: got %s, want %s", got, emptyCard)
+	}
+	if got := VcalendarToJson("BEGIN:VCARD\r\nFN:Alice\r\nEND:VCARD\r\n"); got != string(emptyCalendar) {
+		t.Errorf("VcalendarToJson: got %s, want %s", got, emptyCalendar)
+	}
+	if got := JsonToVcard(`{"FormattedName":`); got != "" {
+		t.Errorf("JsonToVcard: got %q, want \"\"", got)
+	}
+}
+
+func TestConvertPartialOutput(t *testing.T) {
+	// a malformed line stops the decoder, and the fields read so far are
+	// mapped as before
+	b, err := ConvertVcardToJson([]byte("not a vcard"), nil)
+	if err == nil {
+		t.Fatal("got no error")
+	}
+	emptyCard, _ := json.Marshal(Card{})
+	if string(b) != string(emptyCard) {
+		t.Errorf("got %s, want %s", b, emptyCard)
+	}
+
+	b, err = ConvertJsonToVcard([]byte(`{"FormattedName":`), nil)
+	if err == nil || b != nil {
+		t.Errorf("got %q, %v, want no output and an error", b, err)
+	}
+}
+
+type namingBase struct {
+	FormattedName string
+	Note          string
+}
+
+type namingOther struct {
+	Note string
+}
+
+type namingCard struct {
+	namingBase
+	*namingOther
+	Hidden   string `json:"-"`
+	Dash     string `json:"-,"`
+	Tagged   string `json:"x_tagged"`
+	Nickname string
+}
+
+func TestFieldNamingEmbedded(t *testing.T) {
+	opts := &JsonOptions{FieldNaming: SnakeCase}
+	v := namingCard{namingBase: namingBase{"Alice", "a"}, namingOther: &namingOther{"b"}, Hidden: "h", Dash: "d", Tagged: "t", Nickname: "Al"}
+	b, err := opts.marshal(&v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const want = `{"formatted_name":"Alice","-":"d","x_tagged":"t","nickname":"Al"}`
+	if string(b) != want {
+		t.Errorf("got %s, want %s", b, want)
+	}
+
+	var got namingCard
+	if err := opts.unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.FormattedName != "Alice" || got.Dash != "d" || got.Tagged != "t" || got.Nickname != "Al" || got.Hidden != "" {
+		t.Errorf("got %+v", got)
+	}
+}
+
+func TestConvertRoundTrip(t *testing.T) {
+	const card = "BEGIN:VCARD\r\nVERSION:3.0\r\nFN:Alice Example\r\nN:Example;Alice;;;\r\nEND:VCARD\r\n"
+	opts := &JsonOptions{FieldNaming: LowerCamelCase}
+	b, err := ConvertVcardToJson([]byte(card), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), `"formattedName":"Alice Example"`) {
+		t.Errorf("got %s, want key formattedName", b)
+	}
+	out, err := ConvertJsonToVcard(b, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(out), "FN:Alice Example\r\n") || !strings.Contains(string(out), "N:Example;Alice;;;\r\n") {
+		t.Errorf("got %q", out)
+	}
+}
+
+func TestFieldNaming(t *testing.T) {
+	tests := []struct {
+		in, camel, snake string
+	}{
+		{"FormattedName", "formattedName", "formatted_name"},
+		{"UID", "uid", "uid"},
+		{"DTStart", "dtStart", "dt_start"},
+		{"Url", "url", "url"},
+	}
+	for _, test := range tests {
+		if got := LowerCamelCase(test.in); got != test.camel {
+			t.Errorf("LowerCamelCase(%q) = %q, want %q", test.in, got, test.camel)
+		}
+		if got := SnakeCase(test.in); got != test.snake {
+			t.Errorf("SnakeCase(%q) = %q, want %q", test.in, got, test.snake)
+		}
+	}
+}
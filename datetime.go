@@ -0,0 +1,153 @@
+package golib_vcard
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Layouts of DATE and DATE-TIME values. The extended forms are accepted on
+// input since vCard 4.0 and many producers use them.
+const (
+	dateLayout        = "20060102"
+	dateTimeLayout    = "20060102T150405"
+	extDateTimeLayout = "2006-01-02T15:04:05"
+	utcDesignator     = "Z"
+	offsetLayout      = "-0700"
+	extendedOffset    = "-07:00"
+)
+
+// parseDateTime parses a DATE or DATE-TIME value. Times in UTC ("Z") or with a
+// UTC offset keep it, all others are local times in loc, which is time.Local
+// for floating values. date reports whether the value has no time part.
+func parseDateTime(s string, loc *time.Location) (t time.Time, date bool, err error) {
+	if loc == nil {
+		loc = time.Local
+	}
+	layout := dateTimeLayout
+	if len(s) > 4 && s[4] == '-' {
+		layout = extDateTimeLayout
+	}
+	if !strings.Contains(s, "T") {
+		layout = layout[:strings.IndexByte(layout, 'T')]
+		t, err = time.ParseInLocation(layout, s, loc)
+		return t, true, err
+	}
+	switch {
+	case strings.HasSuffix(s, utcDesignator):
+		t, err = time.ParseInLocation(layout+utcDesignator, s, time.UTC)
+	case len(s) > len(layout) && strings.Contains(s[len(layout):], ":"):
+		t, err = time.Parse(layout+extendedOffset, s)
+	case len(s) > len(layout):
+		t, err = time.Parse(layout+offsetLayout, s)
+	default:
+		t, err = time.ParseInLocation(layout, s, loc)
+	}
+	return t, false, err
+}
+
+// formatDateTime formats t as a DATE if date is set, as a UTC DATE-TIME if it
+// is in UTC and as a local DATE-TIME otherwise.
+func formatDateTime(t time.Time, date bool) string {
+	switch {
+	case date:
+		return t.Format(dateLayout)
+	case t.Location() == time.UTC:
+		return t.Format(dateTimeLayout + utcDesignator)
+	}
+	return t.Format(dateTimeLayout)
+}
+
+// parseDuration parses an ISO 8601 duration of the form used by iCalendar,
+// such as "PT15M", "-P1D" or "P1W". Years and months are rejected since they
+// have no fixed length.
+func parseDuration(s string) (time.Duration, error) {
+	orig := s
+	sign := time.Duration(1)
+	if s != "" && (s[0] == '+' || s[0] == '-') {
+		if s[0] == '-' {
+			sign = -1
+		}
+		s = s[1:]
+	}
+	if !strings.HasPrefix(s, "P") || len(s) == 1 {
+		return 0, errors.New("Malformed duration " + strconv.Quote(orig))
+	}
+	s = s[1:]
+	var d time.Duration
+	inTime := false
+	for s != "" {
+		if s[0] == 'T' {
+			inTime = true
+			s = s[1:]
+			continue
+		}
+		i := 0
+		for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+			i++
+		}
+		if i == 0 || i == len(s) {
+			return 0, errors.New("Malformed duration " + strconv.Quote(orig))
+		}
+		n, err := strconv.ParseInt(s[:i], 10, 64)
+		if err != nil {
+			return 0, errors.New("Malformed duration " + strconv.Quote(orig))
+		}
+		var unit time.Duration
+		switch {
+		case !inTime && s[i] == 'W':
+			unit = 7 * 24 * time.Hour
+		case !inTime && s[i] == 'D':
+			unit = 24 * time.Hour
+		case inTime && s[i] == 'H':
+			unit = time.Hour
+		case inTime && s[i] == 'M':
+			unit = time.Minute
+		case inTime && s[i] == 'S':
+			unit = time.Second
+		default:
+			return 0, errors.New("Unsupported duration " + strconv.Quote(orig))
+		}
+		d += time.Duration(n) * unit
+		s = s[i+1:]
+	}
+	return sign * d, nil
+}
+
+// formatDuration formats d as an ISO 8601 duration, using weeks or days only
+// when d is a whole number of them. Fractions of a second are dropped.
+func formatDuration(d time.Duration) string {
+	d = d.Truncate(time.Second)
+	if d == 0 {
+		return "PT0S"
+	}
+	var b strings.Builder
+	if d < 0 {
+		b.WriteByte('-')
+		d = -d
+	}
+	b.WriteByte('P')
+	day := 24 * time.Hour
+	switch {
+	case d%(7*day) == 0:
+		b.WriteString(strconv.FormatInt(int64(d/(7*day)), 10) + "W")
+		return b.String()
+	case d >= day:
+		b.WriteString(strconv.FormatInt(int64(d/day), 10) + "D")
+		d %= day
+	}
+	if d >= time.Second {
+		b.WriteByte('T')
+	}
+	for _, u := range []struct {
+		unit   time.Duration
+		suffix string
+	}{{time.Hour, "H"}, {time.Minute, "M"}, {time.Second, "S"}} {
+		if d >= u.unit {
+			b.WriteString(strconv.FormatInt(int64(d/u.unit), 10) + u.suffix)
+			d %= u.unit
+		}
+	}
+	return b.String()
+}
@@ -6,38 +6,117 @@ package golib_vcard
 import (
 	"bytes"
 	"errors"
+	"fmt"
 	"io"
-	"text/scanner"
+	"strings"
 )
 
 // A Decoder reads Directory Information Blocks from an input stream.
 type Decoder struct {
-	scan        *scanner.Scanner
+	lex         *lexer
 	nextProfile string
+
+	// position of the content line being read and the profiles of the
+	// objects currently open
+	pos      position
+	profiles []string
+	last     *ContentLine
+
+	// CharsetReader, if non-nil, defines a function to generate
+	// charset-conversion readers, converting from the provided CHARSET
+	// parameter into UTF-8. UTF-8, US-ASCII and ISO-8859-1 are handled
+	// without it. Values in any other charset, such as the GBK of many
+	// Chinese phone exports, are kept undecoded unless CharsetReader is set,
+	// for example to one built on golang.org/x/net/html/charset; Errors
+	// reports them as a *CharsetError. NewDecoder initializes it to
+	// DefaultCharsetReader.
+	CharsetReader func(charset string, input io.Reader) (io.Reader, error)
+
+	// Resync makes Next skip a malformed object instead of stopping: the
+	// error is recorded in Errors and decoding resumes at the next BEGIN of
+	// the same profile.
+	Resync bool
+
+	obj  *Object
+	err  error
+	errs []error
 }
 
-// NewDecoder returns a new decoder that reads from r.
+// DefaultCharsetReader is the CharsetReader of the decoders returned by
+// NewDecoder, including those used by Unmarshal and the JSON conversions. It
+// is nil, so only UTF-8, US-ASCII and ISO-8859-1 are decoded and values in
+// other charsets are kept as they are; set it once at start-up to decode GBK
+// and other charsets everywhere:
+//
+//	golib_vcard.DefaultCharsetReader = charset.NewReaderLabel // golang.org/x/net/html/charset
+var DefaultCharsetReader func(charset string, input io.Reader) (io.Reader, error)
+
+// NewDecoder returns a new decoder that reads from r. Lines may end in CRLF,
+// LF or a bare CR; r is buffered by the decoder.
 func NewDecoder(r io.Reader) *Decoder {
-	var s scanner.Scanner
-	s.Init(r)
-	return &Decoder{&s, ""}
+	return &Decoder{lex: newLexer(r), CharsetReader: DefaultCharsetReader}
+}
+
+// A ParseError describes malformed input found by a Decoder.
+type ParseError struct {
+	Line, Column int      // position of the offending text, starting at 1
+	Raw          string   // the offending content line as read
+	Profiles     []string // profiles of the enclosing objects, outermost first
+	Err          error
+}
+
+func (e *ParseError) Error() string {
+	msg := fmt.Sprintf("line %d, column %d: %s", e.Line, e.Column, e.Err.Error())
+	if len(e.Profiles) > 0 {
+		msg += " (in " + strings.Join(e.Profiles, "/") + ")"
+	}
+	return msg
+}
+
+// Unwrap returns the underlying error.
+func (e *ParseError) Unwrap() error {
+	return e.Err
 }
 
-// ReadContentLine reads the next content line and returns it.
+// errorf returns a ParseError for the current content line. The column is
+// taken from pos if it is valid, otherwise the start of the line is used.
+func (dec *Decoder) errorf(pos position, format string, args ...interface{}) error {
+	return dec.wrapError(pos, fmt.Errorf(format, args...))
+}
+
+func (dec *Decoder) wrapError(pos position, err error) error {
+	if !pos.valid() {
+		pos = dec.pos
+	}
+	profiles := make([]string, len(dec.profiles))
+	copy(profiles, dec.profiles)
+	return &ParseError{
+		Line:     pos.line,
+		Column:   pos.column,
+		Raw:      string(dec.lex.raw),
+		Profiles: profiles,
+		Err:      err,
+	}
+}
+
+// ReadContentLine reads the next content line and returns it. Folded lines
+// are joined and blank lines skipped.
 func (dec *Decoder) ReadContentLine() (*ContentLine, error) {
-	dec.skipWhitespace()
-	if dec.scan.Peek() == scanner.EOF {
+	dec.last = nil
+	if !dec.lex.next() {
 		return nil, io.EOF
 	}
-	group, name := dec.readGroupName()
-	params := make(map[string]Value)
-	if dec.scan.Peek() == ';' {
-		dec.scan.Next()
-		params = dec.readParameters()
+	dec.pos = dec.lex.pos(0)
+	group, name, params, raw, off, err := splitContentLine(dec.lex.buf)
+	if err != nil {
+		return nil, dec.wrapError(dec.lex.pos(off), err)
+	}
+	value, err := dec.decodeValues(name, params, raw)
+	if err != nil {
+		return nil, err
 	}
-	dec.scan.Next()
-	value := dec.readValues(name)
-	return &ContentLine{group, name, params, value}, nil
+	dec.last = &ContentLine{group, name, params, value}
+	return dec.last, nil
 }
 
 func (dec *Decoder) expectSingleValue(name string) (string, error) {
@@ -46,12 +125,16 @@ func (dec *Decoder) expectSingleValue(name string) (string, error) {
 		return "", err
 	}
 	if cl.Name != name {
-		return "", errors.New("expected " + name + ", not " + cl.Name)
+		return "", dec.errorf(position{}, "expected %s, not %s", name, cl.Name)
 	}
-	return cl.Value[0][0], nil
+	return cl.Value.GetText(), nil
 }
 
 // ReadObject reads the next object block and returns it.
+//
+// Malformed input is reported as a *ParseError. An input that ends inside
+// an object yields a ParseError wrapping io.ErrUnexpectedEOF, while io.EOF
+// is only returned if there is no further object.
 func (dec *Decoder) ReadObject() (o *Object, err error) {
 	o = &Object{}
 	if dec.nextProfile == "" {
@@ -64,211 +147,261 @@ func (dec *Decoder) ReadObject() (o *Object, err error) {
 		dec.nextProfile = ""
 	}
 
+	dec.profiles = append(dec.profiles, o.Profile)
+	defer func() {
+		dec.profiles = dec.profiles[:len(dec.profiles)-1]
+	}()
+
 	for {
 		cl, err := dec.ReadContentLine()
+		if err == io.EOF {
+			return o, dec.wrapError(dec.lex.end(), io.ErrUnexpectedEOF)
+		}
 		if err != nil {
 			return o, err
 		}
 		if cl.Name == "BEGIN" {
-			dec.nextProfile = cl.Value[0][0]
+			dec.nextProfile = cl.Value.GetText()
+			for _, p := range dec.profiles {
+				if p == dec.nextProfile {
+					dec.nextProfile = ""
+					return o, dec.errorf(position{}, "unexpected BEGIN:%s inside %s", p, p)
+				}
+			}
 			comp, err := dec.ReadObject()
 			if err != nil {
 				return o, err
 			}
 			o.Objects = append(o.Objects, comp)
+			continue
 		}
 		if cl.Name == "END" {
-			if cl.Value[0][0] != o.Profile {
-				return o, errors.New("unexpected END:" + cl.Value[0][0] + ", expected END:" + o.Profile)
+			if cl.Value.GetText() != o.Profile {
+				return o, dec.errorf(position{}, "unexpected END:%s, expected END:%s", cl.Value.GetText(), o.Profile)
 			}
 			break
 		}
-		if err != nil {
-			return o, err
-		}
 		o.Properties = append(o.Properties, cl)
 	}
 	return o, nil
 }
 
-func (dec *Decoder) skipWhitespace() {
-	c := dec.scan.Peek()
-	for c == ' ' || c == '\n' || c == '\r' || c == '\t' {
-		dec.scan.Next()
-		c = dec.scan.Peek()
+// bareParamName returns the parameter a vCard 2.1 bare parameter value such as
+// ";HOME" or ";QUOTED-PRINTABLE" belongs to. Encodings, charsets and value
+// kinds are recognised by their value, everything else is a TYPE.
+func bareParamName(v string) string {
+	u := strings.ToUpper(v)
+	switch u {
+	case "QUOTED-PRINTABLE", "BASE64", "B", "8BIT", "7BIT":
+		return "ENCODING"
+	case "INLINE", "URL", "URI", "CONTENT-ID", "CID":
+		return "VALUE"
+	case "UTF-8", "UTF8", "US-ASCII", "ASCII", "GBK", "GB2312", "GB18030", "BIG5", "SHIFT_JIS", "LATIN1":
+		return "CHARSET"
 	}
-}
-
-func (dec *Decoder) readGroupName() (group, name string) {
-	c := dec.scan.Peek()
-	var buf []rune
-	for c != scanner.EOF {
-		if c == '.' {
-			group = string(buf)
-			buf = []rune{}
-		} else if c == ';' || c == ':' {
-			name = string(buf)
-			return
-		} else {
-			buf = append(buf, c)
+	for _, prefix := range []string{"ISO-8859-", "ISO-2022-", "EUC-", "WINDOWS-12", "KOI8-"} {
+		if strings.HasPrefix(u, prefix) {
+			return "CHARSET"
 		}
-		dec.scan.Next()
-		c = dec.scan.Peek()
 	}
-	return
+	return "TYPE"
 }
 
-func (dec *Decoder) readValue(stopOnEquals bool) string {
-	c := dec.scan.Peek()
-	var buf []rune
+// parseValues splits a raw value into its semicolon-delimited components and
+// comma-delimited value lists, resolving backslash escapes. Every resulting
+// value is passed through decode, if set.
+//
+// Components keep their position: an empty component becomes an empty Value,
+// so "N:;John;;;" yields five components with John in the second. Empty items
+// of a list such as "a,,b" are kept as well.
+func parseValues(name, raw string, decode func(string) (string, error)) (value StructuredValue, err error) {
+	if name == "END" {
+		return StructuredValue{Value{raw}}, nil
+	}
+	if strings.IndexAny(raw, "\\,;") < 0 {
+		// a single value needs no splitting
+		if decode != nil && raw != "" {
+			if raw, err = decode(raw); err != nil {
+				return nil, err
+			}
+		}
+		if raw == "" {
+			return StructuredValue{Value{}}, nil
+		}
+		return StructuredValue{Value{raw}}, nil
+	}
+	buf := make([]byte, 0, len(raw))
+	val := Value{}
 	escape := false
-	for c != scanner.EOF {
-		if c == '\n' {
-			la := dec.scan.Peek()
-			if la != ' ' && la != '\t' {
-				return string(buf)
-			} else {
-				// unfold
-				for c == ' ' || c == '\t' {
-					dec.scan.Next()
-					c = dec.scan.Peek()
-				}
+	flush := func() error {
+		v := string(buf)
+		if decode != nil && v != "" {
+			if v, err = decode(v); err != nil {
+				return err
 			}
 		}
-		if c == '\\' {
-			escape = true
-			dec.scan.Next()
-		} else if escape {
+		val = append(val, v)
+		buf = buf[:0]
+		return nil
+	}
+	endComponent := func() error {
+		if err := flush(); err != nil {
+			return err
+		}
+		if len(val) == 1 && val[0] == "" {
+			val = Value{}
+		}
+		value = append(value, val)
+		val = Value{}
+		return nil
+	}
+	for i := 0; i < len(raw); i++ {
+		c := raw[i]
+		if escape {
 			if c == 'n' || c == 'N' {
 				c = '\n'
 			}
 			buf = append(buf, c)
 			escape = false
-			dec.scan.Next()
-		} else if c == ',' || c == ';' || c == ':' {
-			return string(buf)
-		} else if stopOnEquals && c == '=' {
-			return string(buf)
-		} else if c != '\n' && c != '\r' {
+		} else if c == '\\' {
+			escape = true
+		} else if c == ',' {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+		} else if c == ';' {
+			if err := endComponent(); err != nil {
+				return nil, err
+			}
+		} else {
 			buf = append(buf, c)
-			dec.scan.Next()
 		}
-		c = dec.scan.Peek()
 	}
-	return string(buf)
+	if err := endComponent(); err != nil {
+		return nil, err
+	}
+	return value, nil
 }
 
-func (dec *Decoder) readParameters() (params map[string]Value) {
-	c := dec.scan.Peek()
-	var buf []rune
-	var name string
-	var value string
-	quoted := false
-	params = make(map[string]Value)
-	var values Value
-
-	for c != scanner.EOF {
-		if c == ',' && !quoted {
-			values = append(values, string(buf))
-			buf = []rune{}
-		} else if c == ';' || c == ':' {
-			if name == "" {
-				name = string(buf)
-			} else {
-				value = string(buf)
-			}
-			if name != "" {
-				values = append(values, value)
-				if _, ok := params[name]; ok {
-					params[name] = append(params[name], values...)
-				} else {
-					params[name] = values
-				}
-			}
-			if c == ':' {
-				return
-			}
-			buf = []rune{}
-			values = Value{}
-			name = ""
-			value = ""
-		} else if c == '=' {
-			name = string(buf)
-			buf = []rune{}
-		} else if c == '"' {
-			quoted = !quoted
-		} else {
+// parseURIValue returns raw as a single value. URIs are not split at commas
+// and semicolons, but backslash escapes some producers add are resolved.
+func parseURIValue(name, raw string, decode func(string) (string, error)) (StructuredValue, error) {
+	var buf []byte
+	escape := false
+	for i := 0; i < len(raw); i++ {
+		c := raw[i]
+		if escape || c != '\\' {
 			buf = append(buf, c)
 		}
-		dec.scan.Next()
-		c = dec.scan.Peek()
+		escape = !escape && c == '\\'
+	}
+	v := string(buf)
+	if decode != nil {
+		var err error
+		if v, err = decode(v); err != nil {
+			return nil, err
+		}
 	}
-	return
+	return StructuredValue{Value{v}}, nil
 }
 
-func (dec *Decoder) readValues(name string) (value StructuredValue) {
+// Next advances the decoder to the next top-level object, which will then be
+// available through Object, Card or Calendar. It returns false when the input
+// is exhausted or an error occurs; Err reports the latter.
+//
+// A typical loop over a multi-card file looks like:
+//
+//	dec := NewDecoder(r)
+//	dec.Resync = true
+//	for dec.Next() {
+//		c, err := dec.Card()
+//		...
+//	}
+//	if err := dec.Err(); err != nil { ... }
+//	for _, err := range dec.Errors() { ... }
+func (dec *Decoder) Next() bool {
+	dec.obj = nil
+	if dec.err != nil {
+		return false
+	}
+	for {
+		o, err := dec.ReadObject()
+		if err == nil {
+			dec.obj = o
+			return true
+		}
+		if err == io.EOF {
+			return false
+		}
+		if !dec.Resync {
+			dec.err = err
+			return false
+		}
+		dec.errs = append(dec.errs, err)
+		if !dec.resync(o.Profile) {
+			return false
+		}
+	}
+}
 
-	if name == "END" {
-		var valu Value
-		var buff []rune
-		cc := dec.scan.Next()
-		for cc != scanner.EOF && cc != '\n' && cc != '\r' {
-			buff = append(buff, cc)
-			cc = dec.scan.Next()
+// resync skips content lines up to the next BEGIN of the given profile, or of
+// any profile if it is empty, and reports whether one was found.
+func (dec *Decoder) resync(profile string) bool {
+	dec.nextProfile = ""
+	cl := dec.last
+	for {
+		if cl != nil && cl.Name == "BEGIN" && (profile == "" || cl.Value.GetText() == profile) {
+			dec.nextProfile = cl.Value.GetText()
+			return true
 		}
-		valu = append(valu, string(buff))
-		value = append(value, valu)
-	} else {
-		c := dec.scan.Next()
-		var buf []rune
-		escape := false
-		var val Value
-		for c != scanner.EOF {
-			if c == '\n' {
-				la := dec.scan.Peek()
-				if la != 32 && la != 9 {
-					// return
-					if len(buf) > 0 {
-						val = append(val, string(buf))
-					}
-					value = append(value, val)
-					return
-				} else {
-					// unfold
-					c = dec.scan.Next()
-					for c == 32 || c == 9 {
-						c = dec.scan.Next()
-					}
-				}
-			}
-			if c == '\\' {
-				escape = true
-			} else if escape {
-				if c == 'n' || c == 'N' {
-					c = '\n'
-				}
-				buf = append(buf, c)
-				escape = false
-			} else if c == ',' {
-				if len(buf) > 0 {
-					val = append(val, string(buf))
-					buf = []rune{}
-				}
-			} else if c == ';' {
-				if len(buf) > 0 {
-					val = append(val, string(buf))
-					buf = []rune{}
-				}
-				value = append(value, val)
-				val = Value{}
-			} else if c != '\n' && c != '\r' {
-				buf = append(buf, c)
-			}
-			c = dec.scan.Next()
+		var err error
+		cl, err = dec.ReadContentLine()
+		if err == io.EOF {
+			return false
 		}
 	}
+}
+
+// Object returns the object read by the last call to Next.
+func (dec *Decoder) Object() *Object {
+	return dec.obj
+}
+
+// Card maps the object read by the last call to Next into a Card.
+func (dec *Decoder) Card() (*Card, error) {
+	c := &Card{}
+	if err := dec.current(c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Calendar maps the object read by the last call to Next into a Calendar.
+func (dec *Decoder) Calendar() (*Calendar, error) {
+	c := &Calendar{}
+	if err := dec.current(c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (dec *Decoder) current(v interface{}) error {
+	if dec.obj == nil {
+		return errors.New("No current object, call Next first.")
+	}
+	return FromObject(v, dec.obj)
+}
+
+// Err returns the error that stopped Next, if any. Errors of objects skipped
+// in Resync mode are reported by Errors instead.
+func (dec *Decoder) Err() error {
+	return dec.err
+}
 
-	return
+// Errors returns the errors of all objects skipped in Resync mode and the
+// CharsetErrors of values kept undecoded. They do not stop Next.
+func (dec *Decoder) Errors() []error {
+	return dec.errs
 }
 
 // Decode reads the next object and stores it in the value pointed to by v.
@@ -295,7 +428,34 @@ func (dec *Decoder) Decode(v interface{}) error {
 // structured values. Umarshalling into a struct first maps all struct fields
 // with tag ",param" to the respective parameter values and fills the remaining
 // fields in their index order with the respective semicolon-delimited value
-// components.
+// components. Empty components keep their position, so "ADR:;;Main St;City"
+// fills Street and Locality and leaves PostOfficeBox and ExtendedAddress empty.
+//
+// Other scalar types are parsed as described for Marshal. A time.Time accepts
+// the basic and extended DATE and DATE-TIME forms; times without "Z" or a UTC
+// offset are read in the location of the TZID parameter, or as time.Local if
+// there is none or it is unknown. Values that cannot be parsed are reported as
+// errors, empty values leave the field unchanged.
+//
+// The tag options are those of Marshal. A "multiple" slice collects the values
+// of all properties with its name; without it only the first property is read
+// into a field that is not a slice of structs. Likewise, an "object" field
+// that is not a slice takes the first matching component.
+//
+// A ",grouped" field is filled from the property of its name in the group of
+// the property read into its struct.
+//
+// Embedded structs are filled as described for Marshal. Pointers are
+// allocated when a property or object is stored into them.
+//
+// Values implementing Unmarshaler or ObjectUnmarshaler, or whose address does,
+// are given the content line or object to decode themselves.
+//
+// Properties and objects no other field takes, such as X- properties, are
+// stored in a field with option "extra" (see Marshal), so that they survive a
+// round trip through the struct. Properties beyond the first one for fields
+// holding a single value are kept there as well. Likewise, parameters without
+// a ",param" field go into an "extra" field of type map[string]Value.
 func Unmarshal(data []byte, v interface{}) error {
 	dec := NewDecoder(bytes.NewReader(data))
 	return dec.Decode(v)
@@ -0,0 +1,117 @@
+package golib_vcard
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// nameAndAddress maps only the structured properties so that a round trip
+// through Marshal can be compared byte for byte.
+type nameAndAddress struct {
+	Profile   string `vdir:"vcard,profile"`
+	Version   string
+	Name      Name      `vdir:"n"`
+	Addresses []Address `vdir:"adr"`
+}
+
+func TestStructuredValueRoundTrip(t *testing.T) {
+	tests := []struct {
+		name, line string
+	}{
+		{"given name only", "N:;John;;;"},
+		{"street only", "N:;;;;\r\nADR:;;123 Main St;;;;"},
+		{"street and locality", "N:;;;;\r\nADR:;;Main St;City;;;"},
+		{"list items", "N:Doe;John;Paul,,George;;"},
+		{"all empty", "N:;;;;"},
+	}
+	for _, test := range tests {
+		in := "BEGIN:VCARD\r\nVERSION:3.0\r\n" + test.line + "\r\nEND:VCARD\r\n"
+		var v nameAndAddress
+		if err := Unmarshal([]byte(in), &v); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		out, err := Marshal(&v)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if string(out) != in {
+			t.Errorf("%s: got %q, want %q", test.name, out, in)
+		}
+	}
+}
+
+func TestStructuredValuePositions(t *testing.T) {
+	var c Card
+	in := "BEGIN:VCARD\r\nVERSION:3.0\r\nN:;John;;;\r\nADR:;;123 Main St;;;;\r\nEND:VCARD\r\n"
+	if err := Unmarshal([]byte(in), &c); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(c.Name.FamilyName, "") != "" || len(c.Name.GivenName) != 1 || c.Name.GivenName[0] != "John" {
+		t.Errorf("got family name %q, given name %q", c.Name.FamilyName, c.Name.GivenName)
+	}
+	if len(c.Addresses) != 1 || c.Addresses[0].PostOfficeBox != "" || c.Addresses[0].Street != "123 Main St" {
+		t.Fatalf("got addresses %+v", c.Addresses)
+	}
+	out, err := Marshal(&c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{"N:;John;;;\r\n", "ADR:;;123 Main St;;;;\r\n"} {
+		if !bytes.Contains(out, []byte(line)) {
+			t.Errorf("got %q, want line %q", out, line)
+		}
+	}
+}
+
+func TestObjectRoundTrip(t *testing.T) {
+	in := "BEGIN:VCARD\r\nVERSION:4.0\r\nN:;John;;;\r\nADR;TYPE=home:;;123 Main St;;;;\r\nX-CUSTOM;X-PARAM=a,b:one\\, two;three\r\nEND:VCARD\r\n"
+	o, err := NewDecoder(strings.NewReader(in)).ReadObject()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	if err := NewEncoder(&b).WriteObject(o); err != nil {
+		t.Fatal(err)
+	}
+	if b.String() != in {
+		t.Errorf("got %q, want %q", b.String(), in)
+	}
+}
+
+// benchCards returns n vCards with folded, quoted-printable and grouped
+// properties, about 700 bytes each.
+func benchCards(n int) []byte {
+	var b bytes.Buffer
+	for i := 0; i < n; i++ {
+		b.WriteString("BEGIN:VCARD\r\nVERSION:3.0\r\n")
+		b.WriteString("FN:Alice Example\r\nN:Example;Alice;Marie;Dr.;\r\n")
+		b.WriteString("TEL;TYPE=WORK,VOICE:+1-555-555-0100\r\nTEL;TYPE=CELL:+1-555-555-0199\r\n")
+		b.WriteString("item1.EMAIL;TYPE=INTERNET:alice@example.com\r\nitem1.X-ABLabel:Work\r\n")
+		b.WriteString("ADR;TYPE=HOME:;;123 Main St;Springfield;IL;62701;USA\r\n")
+		b.WriteString("ORG:Example Corp;Research\r\nTITLE:Engineer\r\n")
+		b.WriteString("NOTE:A longer note that is folded over several lines because it exce\r\n eds the seventy-five octet limit of a content line\\, twice over in fa\r\n ct.\r\n")
+		b.WriteString("X-PHONETIC-FIRST-NAME;CHARSET=UTF-8;ENCODING=QUOTED-PRINTABLE:=E5=BC=\r\n=A0=E4=B8=89\r\n")
+		b.WriteString("UID:urn:uuid:f81d4fae-7dec-11d0-a765-00a0c91e6bf6\r\n")
+		b.WriteString("END:VCARD\r\n")
+	}
+	return b.Bytes()
+}
+
+// BenchmarkDecoder reads a file of several megabytes into Objects.
+func BenchmarkDecoder(b *testing.B) {
+	data := benchCards(5000)
+	b.SetBytes(int64(len(data)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		dec := NewDecoder(bytes.NewReader(data))
+		for dec.Next() {
+		}
+		if err := dec.Err(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -4,18 +4,39 @@ import (
 	"bytes"
 	"errors"
 	"io"
+	"sort"
 	"strings"
+	"unicode/utf8"
 )
 
 // An encoder writes Directory Information Blocks to an input stream.
 type Encoder struct {
 	writer io.Writer
 	err    error
+	line   bytes.Buffer
+
+	// FoldWidth is the maximum number of octets per physical line, not
+	// counting the CRLF terminator. Longer content lines are folded with
+	// CRLF followed by a single space. Zero means the limit of 75 octets set
+	// by RFC 6350 section 3.2 and RFC 5545 section 3.1, a negative value
+	// disables folding.
+	FoldWidth int
+
+	// Version, if set to "2.1", "3.0" or "4.0", converts every VCARD object
+	// to that vCard version before writing it: VERSION is set accordingly,
+	// parameters, PREF, inline binary data and GEO take the syntax of the
+	// version, and properties the version does not define are prefixed with
+	// "X-", or dropped if DropUnsupported is set.
+	Version         string
+	DropUnsupported bool
 }
 
+// DefaultFoldWidth is the line length used if Encoder.FoldWidth is zero.
+const DefaultFoldWidth = 75
+
 // NewEncoder returns a new encoder that writes to w.
 func NewEncoder(w io.Writer) *Encoder {
-	return &Encoder{w, nil}
+	return &Encoder{writer: w}
 }
 
 // WriteObject writes an object to the stream.
@@ -23,6 +44,12 @@ func (enc *Encoder) WriteObject(o *Object) error {
 	if o.Profile == "" {
 		return errors.New("No profile set.")
 	}
+	if enc.Version != "" && strings.EqualFold(o.Profile, "VCARD") {
+		var err error
+		if o, err = toVersion(o, enc.Version, enc.DropUnsupported); err != nil {
+			return err
+		}
+	}
 	enc.WriteContentLine(&ContentLine{"", "BEGIN", nil, StructuredValue{Value{o.Profile}}})
 	for _, p := range o.Properties {
 		enc.WriteContentLine(p)
@@ -34,16 +61,58 @@ func (enc *Encoder) WriteObject(o *Object) error {
 	return enc.err
 }
 
+// writeString appends s to the content line being built.
 func (enc *Encoder) writeString(s string) error {
+	enc.line.WriteString(s)
+	return enc.err
+}
+
+// writeLine folds the content line built so far and writes it to the stream.
+func (enc *Encoder) writeLine() error {
 	if enc.err != nil {
 		return enc.err
 	}
-	_, enc.err = io.WriteString(enc.writer, s)
+	width := enc.FoldWidth
+	if width == 0 {
+		width = DefaultFoldWidth
+	}
+	line := enc.line.Bytes()
+	var b bytes.Buffer
+	for max := width; width > 1 && len(line) > max; max = width - 1 {
+		// continuation lines start with a space that counts against width
+		cut := foldPoint(line, max)
+		b.Write(line[:cut])
+		b.WriteString("\r\n ")
+		line = line[cut:]
+	}
+	b.Write(line)
+	b.WriteString("\r\n")
+	enc.line.Reset()
+	_, enc.err = enc.writer.Write(b.Bytes())
 	return enc.err
 }
 
-// WriteContentLine writes a single content line to the stream.
+// foldPoint returns the offset at which line is folded to fit into width
+// octets. It never splits a UTF-8 sequence.
+func foldPoint(line []byte, width int) int {
+	cut := width
+	for cut > 0 && !utf8.RuneStart(line[cut]) {
+		cut--
+	}
+	if cut == 0 {
+		// a single rune wider than width, keep it whole
+		_, n := utf8.DecodeRune(line)
+		return n
+	}
+	return cut
+}
+
+// WriteContentLine writes a single content line to the stream. Parameters are
+// written in canonical order, see paramNames. URI values, such as the data:
+// URIs of vCard 4.0, are written without backslash escapes. Lines longer than
+// FoldWidth are folded.
 func (enc *Encoder) WriteContentLine(cl *ContentLine) error {
+	enc.line.Reset()
 	if cl.Group != "" {
 		enc.writeString(cl.Group)
 		enc.writeString(".")
@@ -51,7 +120,8 @@ func (enc *Encoder) WriteContentLine(cl *ContentLine) error {
 	enc.writeString(cl.Name)
 
 	if cl.Params != nil {
-		for key, values := range cl.Params {
+		for _, key := range paramNames(cl.Params) {
+			values := cl.Params[key]
 			enc.writeString(";")
 			enc.writeString(key)
 			if len(values) > 0 {
@@ -66,6 +136,10 @@ func (enc *Encoder) WriteContentLine(cl *ContentLine) error {
 		}
 	}
 	enc.writeString(":")
+	if isURIValue(cl.Name, cl.Params, cl.Value.GetText()) {
+		enc.writeURIValue(cl.Value.GetText())
+		return enc.writeLine()
+	}
 	for si := 0; si < len(cl.Value); si++ {
 		for vi := 0; vi < len(cl.Value[si]); vi++ {
 			enc.writeValue(cl.Value[si][vi])
@@ -77,17 +151,29 @@ func (enc *Encoder) WriteContentLine(cl *ContentLine) error {
 			enc.writeString(";")
 		}
 	}
-	enc.writeString("\r\n")
-	return enc.err
+	return enc.writeLine()
+}
+
+// paramNames returns the parameter names in canonical order, TYPE first and
+// the rest sorted alphabetically, so that equal content lines always encode
+// to the same bytes.
+func paramNames(params map[string]Value) []string {
+	names := make([]string, 0, len(params))
+	for key := range params {
+		names = append(names, key)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		ti, tj := strings.EqualFold(names[i], "TYPE"), strings.EqualFold(names[j], "TYPE")
+		if ti != tj {
+			return ti
+		}
+		return names[i] < names[j]
+	})
+	return names
 }
 
 func (enc *Encoder) writeValue(v string) error {
-	i := 0
 	for _, c := range v {
-		if i == 76 {
-			enc.writeString("\n  ")
-			i = 0
-		}
 		var e string
 		switch c {
 		case '\r':
@@ -102,11 +188,16 @@ func (enc *Encoder) writeValue(v string) error {
 			e = string(c)
 		}
 		enc.writeString(e)
-		i++
 	}
 	return enc.err
 }
 
+// writeURIValue writes a URI value, which is not escaped.
+func (enc *Encoder) writeURIValue(v string) error {
+	v = strings.Replace(v, "\r", "", -1)
+	return enc.writeString(strings.Replace(v, "\n", "", -1))
+}
+
 func (enc *Encoder) writeParamValue(v string) error {
 	quoted := strings.ContainsAny(v, `:;,`)
 	if quoted {
@@ -141,7 +232,13 @@ func (enc *Encoder) Encode(v interface{}) error {
 // for example a tag "vcard,profile" designates the struct to be of type VCARD.
 //
 // Fields of type string are mapped to single values, string slices to a
-// comma-delimited value list.
+// comma-delimited value list. The same holds for integers, floats, bools
+// (written as TRUE or FALSE), time.Duration (as ISO 8601 duration such as
+// PT1H30M), time.Time and pointers to them. Times in UTC are written as
+// "20060102T150405Z", others as local time with a TZID parameter for their
+// location unless it is time.Local. Midnight is written as a DATE for
+// properties with VALUE=DATE and for BDAY, ANNIVERSARY and DEATHDATE. Zero
+// values are omitted unless held by a pointer.
 //
 // Fields that contain a struct are stored as a structured property with
 // optional parameters and components. If a struct fields tag contains a "param"
@@ -150,7 +247,42 @@ func (enc *Encoder) Encode(v interface{}) error {
 // of appearance in the struct.
 //
 // Fields that have a tag with option "objects" as second value are converted
-// to a new inner BEGIN:PROFILE-END object block.
+// to a new inner BEGIN:PROFILE-END object block, one per element of a slice.
+// If the tag gives no name, as in `vdir:",object"`, the profile is that of the
+// ",profile" field of the element type, so that a field Alarms []Alarm holds
+// VALARM objects; it is the field name if there is no such field.
+//
+// The fields of an untagged embedded struct are promoted into the outer
+// struct as encoding/json does; a field of the outer struct hides an embedded
+// field mapped to the same name. Of several embedded fields at the same depth
+// mapped to one name, only one whose tag gives the name is used; if there is
+// none or more than one, all of them are ignored. Nil pointers, including
+// embedded ones, are skipped.
+//
+// A field with option "extra" of type []*ContentLine,
+// map[string][]*ContentLine or []*Object is written after all other fields,
+// appending its properties or objects unchanged. In a struct mapped to a
+// property, an "extra" field of type map[string]Value adds its parameters.
+//
+// Properties sharing a group, like Apple's "item1.EMAIL" and its custom label
+// "item1.X-ABLabel", are bound by two more options of struct fields mapped to
+// a property. A string field tagged ",group" holds the group of the property
+// and a field tagged "NAME,grouped" the property NAME of the same group,
+// which is written right after it. If it has no group yet, it is given an
+// "itemN" group not used elsewhere in the object.
+//
+// Further options may follow, separated by commas:
+//
+//	omitempty  omit a property without values even if it has parameters
+//	required   fail if the property, object or parameter is missing
+//	multiple   write a slice as one property per element, not as a list
+//	group=G    write the property with group G and only read it from there
+//
+// for example `vdir:"tel,omitempty,group=item1"`. Unknown options are
+// reported as errors.
+//
+// Values implementing Marshaler or ObjectMarshaler encode themselves as a
+// property or an object instead.
 func Marshal(v interface{}) ([]byte, error) {
 	var b bytes.Buffer
 	enc := NewEncoder(&b)
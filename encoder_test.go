@@ -0,0 +1,77 @@
+package golib_vcard
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func encodeLine(t *testing.T, enc *Encoder, b *bytes.Buffer, cl *ContentLine) string {
+	b.Reset()
+	if err := enc.WriteContentLine(cl); err != nil {
+		t.Fatal(err)
+	}
+	return b.String()
+}
+
+func TestEncoderFolding(t *testing.T) {
+	tests := []struct {
+		name  string
+		width int
+		value string
+	}{
+		{"ascii", 0, strings.Repeat("0123456789", 20)},
+		{"whitespace run", 0, "a" + strings.Repeat(" ", 90) + "b"},
+		{"trailing whitespace", 0, strings.Repeat("x", 70) + strings.Repeat(" ", 30)},
+		{"chinese", 0, strings.Repeat("张三李四", 20)},
+		{"mixed", 0, "a" + strings.Repeat("é张", 40)},
+		{"narrow", 10, strings.Repeat("ab张", 10)},
+	}
+	for _, test := range tests {
+		var b bytes.Buffer
+		enc := NewEncoder(&b)
+		enc.FoldWidth = test.width
+		width := test.width
+		if width == 0 {
+			width = DefaultFoldWidth
+		}
+		out := encodeLine(t, enc, &b, &ContentLine{Name: "NOTE", Value: StructuredValue{Value{test.value}}})
+		if !strings.HasSuffix(out, "\r\n") {
+			t.Errorf("%s: got %q without CRLF", test.name, out)
+		}
+		for i, line := range strings.Split(strings.TrimSuffix(out, "\r\n"), "\r\n") {
+			if len(line) > width {
+				t.Errorf("%s: line %d has %d octets: %q", test.name, i, len(line), line)
+			}
+			if !utf8.ValidString(line) {
+				t.Errorf("%s: line %d splits a UTF-8 sequence: %q", test.name, i, line)
+			}
+			if i > 0 && line[0] != ' ' {
+				t.Errorf("%s: continuation line %d does not start with a space: %q", test.name, i, line)
+			}
+			if strings.Contains(line, "\n") {
+				t.Errorf("%s: line %d contains a bare LF", test.name, i)
+			}
+		}
+
+		cl, err := NewDecoder(strings.NewReader(out)).ReadContentLine()
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if got := cl.Value.GetText(); got != test.value {
+			t.Errorf("%s: round trip got %q, want %q", test.name, got, test.value)
+		}
+	}
+}
+
+func TestEncoderNoFolding(t *testing.T) {
+	var b bytes.Buffer
+	enc := NewEncoder(&b)
+	enc.FoldWidth = -1
+	value := strings.Repeat("x", 200)
+	if out := encodeLine(t, enc, &b, &ContentLine{Name: "NOTE", Value: StructuredValue{Value{value}}}); out != "NOTE:"+value+"\r\n" {
+		t.Errorf("got %q", out)
+	}
+}
@@ -0,0 +1,222 @@
+package golib_vcard
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var jcalTypes = map[string]string{
+	"ACKNOWLEDGED":     "date-time",
+	"ATTACH":           "uri",
+	"ATTENDEE":         "cal-address",
+	"COMPLETED":        "date-time",
+	"CREATED":          "date-time",
+	"DTEND":            "date-time",
+	"DTSTAMP":          "date-time",
+	"DTSTART":          "date-time",
+	"DUE":              "date-time",
+	"DURATION":         "duration",
+	"EXDATE":           "date-time",
+	"EXRULE":           "recur",
+	"FREEBUSY":         "period",
+	"GEO":              "float",
+	"LAST-MODIFIED":    "date-time",
+	"ORGANIZER":        "cal-address",
+	"PERCENT-COMPLETE": "integer",
+	"PRIORITY":         "integer",
+	"RDATE":            "date-time",
+	"RECURRENCE-ID":    "date-time",
+	"REFRESH-INTERVAL": "duration",
+	"REPEAT":           "integer",
+	"RRULE":            "recur",
+	"SEQUENCE":         "integer",
+	"SOURCE":           "uri",
+	"TRIGGER":          "duration",
+	"TZOFFSETFROM":     "utc-offset",
+	"TZOFFSETTO":       "utc-offset",
+	"TZURL":            "uri",
+	"URL":              "uri",
+}
+
+// jcalText lists the remaining iCalendar properties, which are text.
+var jcalText = map[string]bool{
+	"ACTION": true, "CALSCALE": true, "CATEGORIES": true, "CLASS": true,
+	"COLOR": true, "COMMENT": true, "CONTACT": true, "DESCRIPTION": true,
+	"LOCATION": true, "METHOD": true, "NAME": true, "PRODID": true,
+	"RELATED-TO": true, "REQUEST-STATUS": true, "RESOURCES": true,
+	"STATUS": true, "SUMMARY": true, "TRANSP": true, "TZID": true,
+	"TZNAME": true, "UID": true, "VERSION": true,
+}
+
+var jcal = &jsonFormat{
+	name:           "jCal",
+	components:     true,
+	upperValueType: true,
+	defaultType: func(name string) string {
+		if t, ok := jcalTypes[name]; ok {
+			return t
+		}
+		if jcalText[name] {
+			return "text"
+		}
+		return "unknown"
+	},
+	structured: map[string]bool{
+		"GEO":            true,
+		"REQUEST-STATUS": true,
+	},
+	encodeLine: encodeRecur,
+	decodeLine: decodeRecur,
+}
+
+// MarshalJCal returns the RFC 7265 jCal encoding of the VCALENDAR object o
+// and all its components:
+//
+//	["vcalendar", [["version", {}, "text", "2.0"], ...],
+//	  [["vevent", [["dtstart", {"tzid": "Europe/Berlin"}, "date-time", "2006-01-02T15:04:05"], ...], []]]]
+//
+// Dates, times and UTC offsets are written in the extended format, integers
+// and floats as JSON numbers, and RRULE as a recur object. Properties unknown
+// to iCalendar are kept with the type "unknown".
+func MarshalJCal(o *Object) ([]byte, error) {
+	v, err := jcal.fromObject(o)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+// UnmarshalJCal parses a jCal into an Object. It reverses MarshalJCal, so the
+// resulting object can be written by an Encoder or mapped into a Calendar.
+func UnmarshalJCal(data []byte) (*Object, error) {
+	return jcal.unmarshal(data)
+}
+
+// recurParts lists the RRULE parts in their canonical order with the JSON
+// type of their values.
+var recurParts = []struct {
+	name string
+	typ  string
+}{
+	{"FREQ", "text"},
+	{"UNTIL", "date-time"},
+	{"COUNT", "integer"},
+	{"INTERVAL", "integer"},
+	{"BYSECOND", "integer"},
+	{"BYMINUTE", "integer"},
+	{"BYHOUR", "integer"},
+	{"BYDAY", "text"},
+	{"BYMONTHDAY", "integer"},
+	{"BYYEARDAY", "integer"},
+	{"BYWEEKNO", "integer"},
+	{"BYMONTH", "integer"},
+	{"BYSETPOS", "integer"},
+	{"WKST", "text"},
+}
+
+func recurPartType(name string) string {
+	for _, p := range recurParts {
+		if p.name == name {
+			return p.typ
+		}
+	}
+	return "text"
+}
+
+// encodeRecur converts an RRULE value, which the Decoder splits into
+// components such as {"FREQ=WEEKLY"}, {"BYDAY=MO", "WE"}, into a jCal recur
+// object.
+func encodeRecur(typ string, v StructuredValue) (interface{}, bool) {
+	if typ != "recur" {
+		return nil, false
+	}
+	var obj orderedObject
+	for _, comp := range v {
+		if len(comp) == 0 {
+			continue
+		}
+		kv := strings.SplitN(comp[0], "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		name := strings.ToUpper(kv[0])
+		values := append(Value{kv[1]}, comp[1:]...)
+		var jv []interface{}
+		for _, s := range values {
+			switch {
+			case name == "UNTIL":
+				if strings.Contains(s, "T") {
+					jv = append(jv, extendedDateTime("date-time", s))
+				} else {
+					jv = append(jv, extendedDateTime("date", s))
+				}
+			case recurPartType(name) == "integer":
+				if i, err := strconv.Atoi(s); err == nil {
+					jv = append(jv, i)
+					break
+				}
+				fallthrough
+			default:
+				jv = append(jv, s)
+			}
+		}
+		if len(jv) == 1 {
+			obj = append(obj, orderedField{strings.ToLower(name), jv[0]})
+		} else {
+			obj = append(obj, orderedField{strings.ToLower(name), jv})
+		}
+	}
+	sort.SliceStable(obj, func(i, j int) bool {
+		return recurOrder(obj[i].key) < recurOrder(obj[j].key)
+	})
+	return obj, true
+}
+
+func recurOrder(key string) int {
+	for i, p := range recurParts {
+		if strings.EqualFold(p.name, key) {
+			return i
+		}
+	}
+	return len(recurParts)
+}
+
+// decodeRecur reverses encodeRecur.
+func decodeRecur(f *jsonFormat, typ string, v interface{}) (StructuredValue, bool, error) {
+	obj, ok := v.(map[string]interface{})
+	if typ != "recur" || !ok {
+		return nil, false, nil
+	}
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		oi, oj := recurOrder(keys[i]), recurOrder(keys[j])
+		if oi != oj {
+			return oi < oj
+		}
+		return keys[i] < keys[j]
+	})
+	var sv StructuredValue
+	for _, k := range keys {
+		name := strings.ToUpper(k)
+		values, err := f.decodeList("", obj[k])
+		if err != nil {
+			return nil, false, err
+		}
+		if name == "UNTIL" {
+			for i := range values {
+				values[i] = basicDateTime(values[i])
+			}
+		}
+		if len(values) == 0 {
+			values = Value{""}
+		}
+		values[0] = name + "=" + values[0]
+		sv = append(sv, values)
+	}
+	return sv, true, nil
+}
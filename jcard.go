@@ -0,0 +1,470 @@
+package golib_vcard
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// jsonFormat describes the differences between jCard and jCal, which share
+// the array layout [name, [properties...], [components...]].
+type jsonFormat struct {
+	name string // "jCard" or "jCal", used in error messages
+
+	// components reports whether the format has a component list
+	components bool
+	// upperValueType writes VALUE parameters in upper case, as iCalendar
+	// does
+	upperValueType bool
+
+	defaultType func(name string) string
+	structured  map[string]bool
+
+	// encodeLine and decodeLine convert whole values of special types such
+	// as jCal's recur, which are neither lists nor structured arrays.
+	encodeLine func(typ string, v StructuredValue) (interface{}, bool)
+	decodeLine func(f *jsonFormat, typ string, v interface{}) (StructuredValue, bool, error)
+}
+
+var jcardStructured = map[string]bool{
+	"N":            true,
+	"ADR":          true,
+	"GENDER":       true,
+	"CLIENTPIDMAP": true,
+}
+
+var jcardTypes = map[string]string{
+	"ANNIVERSARY": "date-and-or-time",
+	"BDAY":        "date-and-or-time",
+	"CALADRURI":   "uri",
+	"CALURI":      "uri",
+	"DEATHDATE":   "date-and-or-time",
+	"FBURL":       "uri",
+	"GEO":         "uri",
+	"IMPP":        "uri",
+	"KEY":         "uri",
+	"LANG":        "language-tag",
+	"LOGO":        "uri",
+	"MEMBER":      "uri",
+	"PHOTO":       "uri",
+	"RELATED":     "uri",
+	"REV":         "timestamp",
+	"SOUND":       "uri",
+	"SOURCE":      "uri",
+	"UID":         "uri",
+	"URL":         "uri",
+}
+
+var jcard = &jsonFormat{
+	name: "jCard",
+	defaultType: func(name string) string {
+		if t, ok := jcardTypes[name]; ok {
+			return t
+		}
+		if _, ok := vcardVersions[name]; ok {
+			return "text"
+		}
+		return "unknown"
+	},
+	structured: jcardStructured,
+}
+
+// MarshalJCard returns the RFC 7095 jCard encoding of the VCARD object o:
+//
+//	["vcard", [["version", {}, "text", "4.0"], ["fn", {}, "text", "J. Doe"], ...]]
+//
+// Every content line becomes a property array with the lower-case name, its
+// parameters (including the group as "group"), the value type taken from the
+// VALUE parameter or the property default, and its values. Structured values
+// such as N and ADR become a single array value. Properties unknown to vCard
+// are kept with the type "unknown".
+func MarshalJCard(o *Object) ([]byte, error) {
+	v, err := jcard.fromObject(o)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+// UnmarshalJCard parses a jCard into an Object. It reverses MarshalJCard, so
+// the resulting object can be written by an Encoder or mapped into a Card.
+func UnmarshalJCard(data []byte) (*Object, error) {
+	return jcard.unmarshal(data)
+}
+
+func (f *jsonFormat) unmarshal(data []byte) (*Object, error) {
+	var v []interface{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return f.toObject(v)
+}
+
+func (f *jsonFormat) fromObject(o *Object) ([]interface{}, error) {
+	if o.Profile == "" {
+		return nil, errors.New("No profile set.")
+	}
+	props := make([]interface{}, 0, len(o.Properties))
+	for _, cl := range o.Properties {
+		props = append(props, f.fromContentLine(cl))
+	}
+	v := []interface{}{strings.ToLower(o.Profile), props}
+	if !f.components {
+		if len(o.Objects) > 0 {
+			return nil, errors.New("Cannot encode inner objects in " + f.name)
+		}
+		return v, nil
+	}
+	comps := make([]interface{}, 0, len(o.Objects))
+	for _, so := range o.Objects {
+		comp, err := f.fromObject(so)
+		if err != nil {
+			return nil, err
+		}
+		comps = append(comps, comp)
+	}
+	return append(v, comps), nil
+}
+
+func (f *jsonFormat) fromContentLine(cl *ContentLine) []interface{} {
+	name := strings.ToUpper(cl.Name)
+	typ := f.defaultType(name)
+	params := make(map[string]interface{})
+	for k, v := range cl.Params {
+		if strings.EqualFold(k, "VALUE") {
+			typ = strings.ToLower(v.GetText())
+			continue
+		}
+		if len(v) == 1 {
+			params[strings.ToLower(k)] = v[0]
+		} else {
+			params[strings.ToLower(k)] = []string(v)
+		}
+	}
+	if cl.Group != "" {
+		params["group"] = cl.Group
+	}
+
+	prop := []interface{}{strings.ToLower(cl.Name), params, typ}
+	if f.encodeLine != nil {
+		if v, ok := f.encodeLine(typ, cl.Value); ok {
+			return append(prop, v)
+		}
+	}
+	if f.structured[name] || len(cl.Value) > 1 {
+		comps := make([]interface{}, len(cl.Value))
+		for i, v := range cl.Value {
+			switch len(v) {
+			case 0:
+				comps[i] = ""
+			case 1:
+				comps[i] = f.encodeValue(typ, v[0])
+			default:
+				list := make([]interface{}, len(v))
+				for j, vv := range v {
+					list[j] = f.encodeValue(typ, vv)
+				}
+				comps[i] = list
+			}
+		}
+		return append(prop, comps)
+	}
+	if len(cl.Value) == 0 || len(cl.Value[0]) == 0 {
+		return append(prop, "")
+	}
+	for _, v := range cl.Value[0] {
+		prop = append(prop, f.encodeValue(typ, v))
+	}
+	return prop
+}
+
+func (f *jsonFormat) toObject(v []interface{}) (*Object, error) {
+	if len(v) < 2 {
+		return nil, f.errorf("component must be an array of name and properties")
+	}
+	name, ok := v[0].(string)
+	if !ok {
+		return nil, f.errorf("component name must be a string")
+	}
+	props, ok := v[1].([]interface{})
+	if !ok {
+		return nil, f.errorf("properties of %s must be an array", name)
+	}
+	o := &Object{Profile: strings.ToUpper(name)}
+	for _, p := range props {
+		pa, ok := p.([]interface{})
+		if !ok {
+			return nil, f.errorf("property in %s must be an array", name)
+		}
+		cl, err := f.toContentLine(pa)
+		if err != nil {
+			return nil, err
+		}
+		o.Properties = append(o.Properties, cl)
+	}
+	if len(v) < 3 {
+		return o, nil
+	}
+	comps, ok := v[2].([]interface{})
+	if !ok || !f.components {
+		return nil, f.errorf("unexpected components in %s", name)
+	}
+	for _, c := range comps {
+		ca, ok := c.([]interface{})
+		if !ok {
+			return nil, f.errorf("component in %s must be an array", name)
+		}
+		so, err := f.toObject(ca)
+		if err != nil {
+			return nil, err
+		}
+		o.Objects = append(o.Objects, so)
+	}
+	return o, nil
+}
+
+func (f *jsonFormat) toContentLine(p []interface{}) (*ContentLine, error) {
+	if len(p) < 4 {
+		return nil, f.errorf("property must have name, parameters, type and value")
+	}
+	name, ok1 := p[0].(string)
+	params, ok2 := p[1].(map[string]interface{})
+	typ, ok3 := p[2].(string)
+	if !ok1 || !ok2 || !ok3 {
+		return nil, f.errorf("malformed property %v", p[0])
+	}
+	cl := &ContentLine{Name: strings.ToUpper(name)}
+	for k, v := range params {
+		if k == "group" {
+			s, _ := v.(string)
+			cl.Group = s
+			continue
+		}
+		val, err := f.decodeList("", v)
+		if err != nil {
+			return nil, err
+		}
+		if cl.Params == nil {
+			cl.Params = make(map[string]Value)
+		}
+		cl.Params[strings.ToUpper(k)] = val
+	}
+	if typ != "unknown" && typ != f.defaultType(cl.Name) {
+		if cl.Params == nil {
+			cl.Params = make(map[string]Value)
+		}
+		if f.upperValueType {
+			typ = strings.ToUpper(typ)
+		}
+		cl.Params["VALUE"] = Value{typ}
+		typ = strings.ToLower(typ)
+	}
+
+	values := p[3:]
+	if f.decodeLine != nil && len(values) == 1 {
+		v, ok, err := f.decodeLine(f, typ, values[0])
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			cl.Value = v
+			return cl, nil
+		}
+	}
+	if comps, ok := values[0].([]interface{}); ok && len(values) == 1 {
+		return f.structuredLine(cl, typ, comps)
+	}
+	val := Value{}
+	for _, v := range values {
+		s, err := f.decodeScalar(typ, v)
+		if err != nil {
+			return nil, err
+		}
+		val = append(val, s)
+	}
+	cl.Value = StructuredValue{val}
+	return cl, nil
+}
+
+func (f *jsonFormat) structuredLine(cl *ContentLine, typ string, comps []interface{}) (*ContentLine, error) {
+	for _, c := range comps {
+		val, err := f.decodeList(typ, c)
+		if err != nil {
+			return nil, err
+		}
+		if len(val) == 1 && val[0] == "" {
+			val = Value{}
+		}
+		cl.Value = append(cl.Value, val)
+	}
+	return cl, nil
+}
+
+// decodeList decodes a string or an array of strings.
+func (f *jsonFormat) decodeList(typ string, v interface{}) (Value, error) {
+	if list, ok := v.([]interface{}); ok {
+		val := make(Value, 0, len(list))
+		for _, item := range list {
+			s, err := f.decodeScalar(typ, item)
+			if err != nil {
+				return nil, err
+			}
+			val = append(val, s)
+		}
+		return val, nil
+	}
+	s, err := f.decodeScalar(typ, v)
+	if err != nil {
+		return nil, err
+	}
+	return Value{s}, nil
+}
+
+// encodeValue converts a text value into its JSON form for the given type.
+func (f *jsonFormat) encodeValue(typ, v string) interface{} {
+	switch typ {
+	case "integer":
+		if i, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return i
+		}
+	case "float":
+		if x, err := strconv.ParseFloat(v, 64); err == nil {
+			return x
+		}
+	case "boolean":
+		switch strings.ToUpper(v) {
+		case "TRUE":
+			return true
+		case "FALSE":
+			return false
+		}
+	case "date", "time", "date-time", "date-and-or-time", "timestamp", "utc-offset":
+		return extendedDateTime(typ, v)
+	case "period":
+		if i := strings.IndexByte(v, '/'); i >= 0 {
+			return extendedDateTime("date-time", v[:i]) + "/" + extendedPeriodEnd(v[i+1:])
+		}
+	}
+	return v
+}
+
+// extendedPeriodEnd converts the end of a period, which is either a
+// date-time or a duration.
+func extendedPeriodEnd(v string) string {
+	if strings.HasPrefix(strings.TrimLeft(v, "+-"), "P") {
+		return v
+	}
+	return extendedDateTime("date-time", v)
+}
+
+// decodeScalar converts a JSON value back to its text form.
+func (f *jsonFormat) decodeScalar(typ string, v interface{}) (string, error) {
+	switch x := v.(type) {
+	case string:
+		switch typ {
+		case "date", "time", "date-time", "date-and-or-time", "timestamp", "utc-offset":
+			return basicDateTime(x), nil
+		case "period":
+			if i := strings.IndexByte(x, '/'); i >= 0 {
+				return basicDateTime(x[:i]) + "/" + basicDateTime(x[i+1:]), nil
+			}
+		}
+		return x, nil
+	case json.Number:
+		return x.String(), nil
+	case float64:
+		return strconv.FormatFloat(x, 'f', -1, 64), nil
+	case bool:
+		if x {
+			return "TRUE", nil
+		}
+		return "FALSE", nil
+	case nil:
+		return "", nil
+	}
+	return "", f.errorf("unexpected %s value %v", typ, v)
+}
+
+func (f *jsonFormat) errorf(format string, args ...interface{}) error {
+	return errors.New("Malformed " + f.name + ": " + fmt.Sprintf(format, args...))
+}
+
+// extendedDateTime converts a date, time, date-time or UTC offset from the
+// basic format used in vCard and iCalendar ("19850412T101500-0500") to the
+// extended format required by jCard and jCal ("1985-04-12T10:15:00-05:00").
+// Values that are not recognised are returned unchanged.
+func extendedDateTime(typ, v string) string {
+	if typ == "utc-offset" {
+		return extendedZone(v)
+	}
+	date, clock := v, ""
+	if i := strings.IndexByte(v, 'T'); i >= 0 {
+		date, clock = v[:i], v[i+1:]
+	} else if typ == "time" {
+		date, clock = "", v
+	}
+	switch {
+	case strings.HasPrefix(date, "---") || strings.Contains(strings.TrimLeft(date, "-"), "-"):
+	case strings.HasPrefix(date, "--") && len(date) == 6:
+		date = date[:4] + "-" + date[4:]
+	case len(date) == 8 && isDigits(date):
+		date = date[:4] + "-" + date[4:6] + "-" + date[6:]
+	}
+	if clock == "" && !strings.Contains(v, "T") {
+		return date
+	}
+	zone := ""
+	if i := strings.IndexAny(clock, "Z+-"); i > 0 {
+		clock, zone = clock[:i], clock[i:]
+	}
+	if isDigits(clock) && len(clock)%2 == 0 {
+		var parts []string
+		for i := 0; i < len(clock); i += 2 {
+			parts = append(parts, clock[i:i+2])
+		}
+		clock = strings.Join(parts, ":")
+	}
+	clock += extendedZone(zone)
+	if date == "" && typ == "time" {
+		return clock
+	}
+	return date + "T" + clock
+}
+
+func extendedZone(z string) string {
+	if len(z) == 5 && (z[0] == '+' || z[0] == '-') && isDigits(z[1:]) {
+		return z[:3] + ":" + z[3:]
+	}
+	return z
+}
+
+// basicDateTime reverses extendedDateTime.
+func basicDateTime(v string) string {
+	date, clock, sep := v, "", ""
+	if i := strings.IndexByte(v, 'T'); i >= 0 {
+		date, clock, sep = v[:i], v[i+1:], "T"
+	} else if strings.Contains(v, ":") {
+		date, clock = "", v
+	}
+	if len(date) == 7 && date[4] == '-' {
+		// year and month keep their hyphen in vCard as well
+		return v
+	}
+	lead := len(date) - len(strings.TrimLeft(date, "-"))
+	date = date[:lead] + strings.Replace(date[lead:], "-", "", -1)
+	return date + sep + strings.Replace(clock, ":", "", -1)
+}
+
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return len(s) > 0
+}
@@ -1,57 +1,50 @@
 package golib_vcard
 
 import (
-	"encoding/json"
 	"fmt"
 )
 
 //Json格式转为Vcard
 func JsonToVcard(jsonstr string) string {
-	var c Card
-	var jtovc string
-	if err := json.Unmarshal([]byte(jsonstr), &c); err == nil {
-		b, err := Marshal(c)
-		if err != nil {
-			fmt.Println("json转vcard错误")
-		}
-		jtovc = string(b)
-
-	} else {
+	b, err := ConvertJsonToVcard([]byte(jsonstr), nil)
+	if err != nil {
 		fmt.Println("json转vcard错误")
 	}
-	return jtovc
+	return string(b)
 }
 
 //Json转为Valendar格式
 func JsonToVcalendar(jsonstr string) string {
-	var c Calendar
-	var jtovc string
-	if err := json.Unmarshal([]byte(jsonstr), &c); err == nil {
-		b, err := Marshal(c)
-		if err != nil {
-			fmt.Println(err)
-		}
-		jtovc = string(b)
-
-	} else {
-		fmt.Println("json转Vcalendar错误2")
+	b, err := ConvertJsonToVcalendar([]byte(jsonstr), nil)
+	if err != nil {
+		fmt.Println(err)
 	}
-	return jtovc
+	return string(b)
 }
 
 //Json转为短信格式
 func JsonToBjson(jsonstr string) string {
-	var c BJSON
-	var jtovc string
-	if err := json.Unmarshal([]byte(jsonstr), &c); err == nil {
-		b, err := Marshal(c)
-		if err != nil {
-			fmt.Println(err)
-		}
-		jtovc = string(b)
-
-	} else {
-		fmt.Println("json转Vcalendar错误2")
+	b, err := ConvertJsonToBjson([]byte(jsonstr), nil)
+	if err != nil {
+		fmt.Println(err)
 	}
-	return jtovc
+	return string(b)
+}
+
+//Json格式转为Vcard，失败时返回 *ConvertError，编码失败时同时返回已编码的部分
+func ConvertJsonToVcard(data []byte, opts *JsonOptions) ([]byte, error) {
+	var c Card
+	return encodeFromJson(data, &c, opts)
+}
+
+//Json转为Valendar格式，失败时返回 *ConvertError，编码失败时同时返回已编码的部分
+func ConvertJsonToVcalendar(data []byte, opts *JsonOptions) ([]byte, error) {
+	var c Calendar
+	return encodeFromJson(data, &c, opts)
+}
+
+//Json转为短信格式，失败时返回 *ConvertError，编码失败时同时返回已编码的部分
+func ConvertJsonToBjson(data []byte, opts *JsonOptions) ([]byte, error) {
+	var c BJSON
+	return encodeFromJson(data, &c, opts)
 }
@@ -0,0 +1,292 @@
+package golib_vcard
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+)
+
+// position is a line and column in the input, both starting at 1.
+type position struct {
+	line, column int
+}
+
+func (p position) valid() bool {
+	return p.line > 0
+}
+
+// segment records where a physical line starts in the unfolded logical line,
+// so that offsets in the latter can be reported as input positions.
+type segment struct {
+	off    int // offset in the logical line
+	line   int // physical line number
+	column int // column of the first byte taken from the physical line
+}
+
+// lexer reads unfolded logical content lines from a bufio.Reader. Lines may
+// end in CRLF, LF or a bare CR. Its buffers are reused from line to line.
+type lexer struct {
+	r     *bufio.Reader
+	line  int    // number of physical lines read
+	phys  []byte // the physical line last read
+	ahead bool   // phys holds a line read ahead but not yet consumed
+	eof   bool
+
+	buf  []byte // the current logical line
+	raw  []byte // the current logical line as read, with line breaks
+	segs []segment
+}
+
+func newLexer(r io.Reader) *lexer {
+	return &lexer{r: bufio.NewReaderSize(r, 64*1024)}
+}
+
+// readPhysical reads the next physical line into l.phys without its line
+// ending. It returns false at the end of the input.
+func (l *lexer) readPhysical() bool {
+	if l.ahead {
+		l.ahead = false
+		return true
+	}
+	if l.eof {
+		return false
+	}
+	l.phys = l.phys[:0]
+	for {
+		// look at the buffered input only, refilling it when it is empty
+		b, _ := l.r.Peek(l.r.Buffered())
+		if len(b) == 0 {
+			if _, err := l.r.Peek(1); err != nil {
+				l.eof = true
+				if len(l.phys) == 0 {
+					return false
+				}
+				l.line++
+				return true
+			}
+			continue
+		}
+		i := bytes.IndexAny(b, "\r\n")
+		if i < 0 {
+			l.phys = append(l.phys, b...)
+			l.r.Discard(len(b))
+			continue
+		}
+		l.phys = append(l.phys, b[:i]...)
+		l.r.Discard(i + 1)
+		if b[i] == '\r' {
+			if next, err := l.r.Peek(1); err == nil && next[0] == '\n' {
+				l.r.Discard(1)
+			}
+		}
+		l.line++
+		return true
+	}
+}
+
+// next reads the next logical line into l.buf, skipping blank lines. Folded
+// continuation lines are joined after stripping their first character, a space
+// or tab, as RFC 6350 section 3.2 and RFC 5545 section 3.1 require and,
+// in a quoted-printable line, a trailing '=' joins the next physical line as
+// a soft line break. It returns false at the end of the input.
+func (l *lexer) next() bool {
+	for {
+		if !l.readPhysical() {
+			return false
+		}
+		if leadingSpace(l.phys) < len(l.phys) {
+			break
+		}
+	}
+	l.buf = l.buf[:0]
+	l.raw = l.raw[:0]
+	l.segs = l.segs[:0]
+	n := leadingSpace(l.phys)
+	l.add(l.phys[n:], n+1)
+	for l.readPhysical() {
+		switch {
+		case len(l.phys) > 0 && (l.phys[0] == ' ' || l.phys[0] == '\t'):
+			// only the line break and the one whitespace character that
+			// follows it are removed
+			l.add(l.phys[1:], 2)
+		case len(l.buf) > 0 && l.buf[len(l.buf)-1] == '=' && l.quotedPrintable():
+			l.buf = l.buf[:len(l.buf)-1]
+			l.add(l.phys, 1)
+		default:
+			l.ahead = true
+			return true
+		}
+	}
+	return true
+}
+
+// leadingSpace returns the number of spaces and tabs b starts with.
+func leadingSpace(b []byte) int {
+	n := 0
+	for n < len(b) && (b[n] == ' ' || b[n] == '\t') {
+		n++
+	}
+	return n
+}
+
+func (l *lexer) add(b []byte, column int) {
+	if len(l.segs) > 0 {
+		l.raw = append(l.raw, '\n')
+	}
+	l.raw = append(l.raw, l.phys...)
+	l.segs = append(l.segs, segment{off: len(l.buf), line: l.line, column: column})
+	l.buf = append(l.buf, b...)
+}
+
+// quotedPrintable reports whether the parameters of the current line declare
+// the quoted-printable encoding, as ENCODING=QUOTED-PRINTABLE or as the bare
+// QUOTED-PRINTABLE of vCard 2.1. The group and name are not looked at.
+func (l *lexer) quotedPrintable() bool {
+	end := valueStart(l.buf)
+	if end < 0 {
+		return false
+	}
+	const qp = "QUOTED-PRINTABLE"
+	start := bytes.IndexByte(l.buf[:end], ';')
+	if start < 0 {
+		return false
+	}
+	quoted := false
+	for i := start + 1; i <= end; i++ {
+		if i < end && l.buf[i] == '"' {
+			quoted = !quoted
+		}
+		if i < end && (quoted || l.buf[i] != ';') {
+			continue
+		}
+		param := l.buf[start+1 : i]
+		start = i
+		if eq := bytes.IndexByte(param, '='); eq >= 0 {
+			if !bytes.EqualFold(bytes.TrimSpace(param[:eq]), []byte("ENCODING")) {
+				continue
+			}
+			param = bytes.Trim(param[eq+1:], "\" \t")
+		}
+		if bytes.EqualFold(param, []byte(qp)) {
+			return true
+		}
+	}
+	return false
+}
+
+// valueStart returns the offset of the ':' that separates name and parameters
+// from the value of line, or -1.
+func valueStart(line []byte) int {
+	quoted := false
+	for i, c := range line {
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case c == ':' && !quoted:
+			return i
+		}
+	}
+	return -1
+}
+
+// pos returns the input position of offset off in the logical line.
+func (l *lexer) pos(off int) position {
+	if len(l.segs) == 0 {
+		return position{l.line + 1, 1}
+	}
+	s := l.segs[0]
+	for _, seg := range l.segs[1:] {
+		if seg.off > off {
+			break
+		}
+		s = seg
+	}
+	if off > len(l.buf) {
+		off = len(l.buf)
+	}
+	return position{s.line, s.column + utf8.RuneCount(l.buf[s.off:off])}
+}
+
+// end returns the position after the last line read.
+func (l *lexer) end() position {
+	return position{l.line + 1, 1}
+}
+
+// splitContentLine splits the logical line into group, name, parameters and
+// the raw value. Parameter names are upper-cased; vCard 2.1 bare parameters
+// are assigned to the parameter they belong to. If the line is malformed, off
+// is the offset of the offending text.
+func splitContentLine(line []byte) (group, name string, params map[string]Value, value string, off int, err error) {
+	i := 0
+	start := 0
+	for ; i < len(line) && line[i] != ';' && line[i] != ':'; i++ {
+		if line[i] == '.' {
+			group = string(line[start:i])
+			start = i + 1
+		}
+	}
+	if i == len(line) {
+		return "", "", nil, "", i, fmt.Errorf("missing ':' after property name %q", line[start:])
+	}
+	name = string(line[start:i])
+	params = make(map[string]Value)
+	if line[i] == ';' {
+		n, ok := parseParameters(line[i+1:], params)
+		if !ok {
+			return "", "", nil, "", len(line), fmt.Errorf("missing ':' after parameters of %s", name)
+		}
+		i += 1 + n
+	}
+	return group, name, params, string(line[i+1:]), 0, nil
+}
+
+// parseParameters parses the parameters in b up to the ':' that starts the
+// value, adding them to params. It returns the offset of the ':'.
+func parseParameters(b []byte, params map[string]Value) (int, bool) {
+	var name string
+	named := false
+	quoted := false
+	var values Value
+	var buf []byte
+	for i, c := range b {
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case quoted:
+			buf = append(buf, c)
+		case c == ',':
+			values = append(values, string(buf))
+			buf = buf[:0]
+		case c == ';' || c == ':':
+			values = append(values, string(buf))
+			if named {
+				name = strings.ToUpper(name)
+				params[name] = append(params[name], values...)
+			} else {
+				for _, v := range values {
+					if v != "" {
+						name = bareParamName(v)
+						params[name] = append(params[name], v)
+					}
+				}
+			}
+			if c == ':' {
+				return i, true
+			}
+			buf = buf[:0]
+			values = nil
+			name = ""
+			named = false
+		case c == '=' && !named:
+			name = string(buf)
+			named = true
+			buf = buf[:0]
+		default:
+			buf = append(buf, c)
+		}
+	}
+	return len(b), false
+}
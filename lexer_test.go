@@ -0,0 +1,78 @@
+package golib_vcard
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLexerUnfolding(t *testing.T) {
+	tests := []struct {
+		name, in string
+		want     []string
+	}{
+		{"crlf", "A:1\r\nB:2\r\n", []string{"A:1", "B:2"}},
+		{"lf", "A:1\nB:2\n", []string{"A:1", "B:2"}},
+		{"bare cr", "A:1\rB:2\r", []string{"A:1", "B:2"}},
+		{"no final line break", "A:1\r\nB:2", []string{"A:1", "B:2"}},
+		{"blank lines", "A:1\r\n\r\n  \r\nB:2\r\n", []string{"A:1", "B:2"}},
+		{"fold with space", "NOTE:Hel\r\n lo\r\n", []string{"NOTE:Hello"}},
+		{"fold with tab", "NOTE:Hel\r\n\tlo\r\n", []string{"NOTE:Hello"}},
+		{"fold keeps further whitespace", "NOTE:Hello\r\n  World\r\n", []string{"NOTE:Hello World"}},
+		{"fold inside whitespace run", "NOTE:a \r\n  \r\n  b\r\n", []string{"NOTE:a   b"}},
+		{"fold in name and parameters", "TE\r\n L;TY\r\n PE=home:1\r\n", []string{"TEL;TYPE=home:1"}},
+		{"quoted-printable soft break", "NOTE;ENCODING=QUOTED-PRINTABLE:a=\r\nb\r\nX:1\r\n", []string{"NOTE;ENCODING=QUOTED-PRINTABLE:ab", "X:1"}},
+		{"trailing = without quoted-printable", "NOTE:a=\r\nX:1\r\n", []string{"NOTE:a=", "X:1"}},
+		{"bare quoted-printable parameter", "NOTE;QUOTED-PRINTABLE:a=\r\nb\r\n", []string{"NOTE;QUOTED-PRINTABLE:ab"}},
+		{"lower-case encoding", "NOTE;encoding=quoted-printable:a=\r\nb\r\n", []string{"NOTE;encoding=quoted-printable:ab"}},
+		{"encoding after a quoted parameter", "NOTE;X-A=\"a;b:c\";ENCODING=QUOTED-PRINTABLE:a=\r\nb\r\n", []string{"NOTE;X-A=\"a;b:c\";ENCODING=QUOTED-PRINTABLE:ab"}},
+		{"quoted-printable in the name", "X-QUOTED-PRINTABLE:a=\r\nX:1\r\n", []string{"X-QUOTED-PRINTABLE:a=", "X:1"}},
+		{"quoted-printable in the group", "QUOTED-PRINTABLE.NOTE:a=\r\nX:1\r\n", []string{"QUOTED-PRINTABLE.NOTE:a=", "X:1"}},
+		{"quoted-printable in another parameter", "NOTE;X-A=QUOTED-PRINTABLE:a=\r\nX:1\r\n", []string{"NOTE;X-A=QUOTED-PRINTABLE:a=", "X:1"}},
+	}
+	for _, test := range tests {
+		l := newLexer(strings.NewReader(test.in))
+		var got []string
+		for l.next() {
+			got = append(got, string(l.buf))
+		}
+		if strings.Join(got, "|") != strings.Join(test.want, "|") {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestLexerPositions(t *testing.T) {
+	l := newLexer(strings.NewReader("A:1\r\nNOTE:ab\r\n cd\r\n"))
+	l.next()
+	l.next()
+	tests := []struct {
+		off  int
+		want position
+	}{
+		{0, position{2, 1}},
+		{5, position{2, 6}},
+		{7, position{3, 2}},
+		{8, position{3, 3}},
+	}
+	for _, test := range tests {
+		if got := l.pos(test.off); got != test.want {
+			t.Errorf("pos(%d) = %v, want %v", test.off, got, test.want)
+		}
+	}
+}
+
+func TestSplitContentLine(t *testing.T) {
+	group, name, params, value, _, err := splitContentLine([]byte(`item1.TEL;TYPE=home,voice;X-A="a;b:c":+1 555`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if group != "item1" || name != "TEL" || value != "+1 555" {
+		t.Errorf("got group %q, name %q, value %q", group, name, value)
+	}
+	if strings.Join(params["TYPE"], ",") != "home,voice" || strings.Join(params["X-A"], ",") != "a;b:c" {
+		t.Errorf("got params %q", params)
+	}
+	if _, _, _, _, _, err := splitContentLine([]byte("TEL;TYPE=home")); err == nil {
+		t.Error("got no error for a line without ':'")
+	}
+}
@@ -0,0 +1,766 @@
+package golib_vcard
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Time returns the date or date-time of v. Local times are read in the
+// location of the TZID parameter, or as time.Local if there is none or it is
+// unknown. date reports whether v is a DATE.
+func (v DateTimeValue) Time() (t time.Time, date bool, err error) {
+	return parseDateTime(v.Value, tzLocation(v.TZId))
+}
+
+// tzLocation returns the location of a TZID, or time.Local if it is empty or
+// unknown, such as a Windows zone name.
+func tzLocation(tzid string) *time.Location {
+	if tzid == "" {
+		return time.Local
+	}
+	if l, err := time.LoadLocation(strings.Trim(tzid, "/")); err == nil {
+		return l
+	}
+	return time.Local
+}
+
+// Occurrence is a single instance of a recurring event or to-do.
+type Occurrence struct {
+	Start time.Time
+	End   time.Time
+
+	// RecurrenceID is the start the instance has in the recurrence set,
+	// which differs from Start for an instance moved by an override.
+	RecurrenceID time.Time
+
+	// Event or Todo is the component the instance belongs to: the one
+	// expanded or, for an overridden instance, its override.
+	Event *Event
+	Todo  *Todo
+}
+
+// OccurrenceIterator steps through the occurrences of an event or to-do in
+// the order of their start:
+//
+//	it, err := event.Occurrences(from, to, nil)
+//	if err != nil {
+//		return err
+//	}
+//	for it.Next() {
+//		o := it.Occurrence()
+//		...
+//	}
+type OccurrenceIterator struct {
+	from, to  time.Time
+	rule      *expander
+	extra     []Occurrence // RDATEs and overrides, sorted by start
+	exdates   map[int64]bool
+	overrides map[int64]bool
+	span      span
+	master    Occurrence
+	pending   *Occurrence
+	last      time.Time
+	cur       Occurrence
+}
+
+// span is the length of an occurrence, in days for date values so that it
+// keeps its length across daylight saving time changes.
+type span struct {
+	days int
+	d    time.Duration
+}
+
+func (s span) end(start time.Time) time.Time {
+	return start.AddDate(0, 0, s.days).Add(s.d)
+}
+
+// component holds the recurrence properties common to events and to-dos.
+type component struct {
+	dtstart  DateTimeValue
+	end      string // DTEND or DUE
+	endTZID  string
+	duration string
+	rrule    *Recurrence
+	rdate    []DateTimeList
+	exdate   []DateTimeList
+}
+
+// Occurrences returns an iterator over the occurrences of e that overlap the
+// window from to to. A zero to leaves the window open, in which case the
+// iteration of an endless rule does not end.
+//
+// The recurrence set is made of DTSTART, the instances of RRULE and RDATE
+// less those of EXDATE. The rule is expanded in the time zone of DTSTART, so
+// that an event at 9:00 stays at 9:00 across daylight saving time changes.
+// overrides are the events with the same UID and a RECURRENCE-ID; they
+// replace the instance they name. The RANGE parameter is not supported.
+// An event without DTSTART has no occurrences.
+func (e *Event) Occurrences(from, to time.Time, overrides []Event) (*OccurrenceIterator, error) {
+	c := component{e.DTStart, e.DTEnd.Value, e.DTEnd.TZId, e.Duration, e.RRule, e.RDate, e.ExDate}
+	it, err := c.occurrences(from, to, Occurrence{Event: e})
+	if err != nil {
+		return nil, err
+	}
+	for i := range overrides {
+		o := &overrides[i]
+		oc := component{dtstart: o.DTStart, end: o.DTEnd.Value, endTZID: o.DTEnd.TZId, duration: o.Duration}
+		if err := it.override(o.RecurrenceID, oc, Occurrence{Event: o}); err != nil {
+			return nil, err
+		}
+	}
+	it.sortExtra()
+	return it, nil
+}
+
+// Occurrences returns an iterator over the occurrences of t that overlap the
+// window from to to, as described for Event. The end of an occurrence is
+// given by DUE, read in the time zone of its own TZID, or DURATION; a to-do
+// without DTSTART has no occurrences.
+func (t *Todo) Occurrences(from, to time.Time, overrides []Todo) (*OccurrenceIterator, error) {
+	c := component{t.DTStart, t.Due.Value, t.Due.TZId, t.Duration, t.RRule, t.RDate, t.ExDate}
+	it, err := c.occurrences(from, to, Occurrence{Todo: t})
+	if err != nil {
+		return nil, err
+	}
+	for i := range overrides {
+		o := &overrides[i]
+		oc := component{dtstart: o.DTStart, end: o.Due.Value, endTZID: o.Due.TZId, duration: o.Duration}
+		if err := it.override(o.RecurrenceID, oc, Occurrence{Todo: o}); err != nil {
+			return nil, err
+		}
+	}
+	it.sortExtra()
+	return it, nil
+}
+
+// Occurrences returns the occurrences of all events and to-dos of c that
+// overlap the window from to to, sorted by start. Components with a
+// RECURRENCE-ID override the instance of the component with their UID; one
+// without such a component is an occurrence of its own, whose RecurrenceID
+// is its RECURRENCE-ID.
+func (c *Calendar) Occurrences(from, to time.Time) ([]Occurrence, error) {
+	if to.IsZero() {
+		return nil, errors.New("Cannot list occurrences without the end of the window")
+	}
+	var all []Occurrence
+	add := func(it *OccurrenceIterator, err error) error {
+		if err != nil || it == nil {
+			return err
+		}
+		for it.Next() {
+			all = append(all, it.Occurrence())
+		}
+		return nil
+	}
+	// addOrphan adds an override without its recurring component, which
+	// keeps the RECURRENCE-ID it names.
+	addOrphan := func(rid DateTimeValue, it *OccurrenceIterator, err error) error {
+		if err != nil || it == nil {
+			return err
+		}
+		id, _, err := rid.Time()
+		if err != nil {
+			return err
+		}
+		for it.Next() {
+			o := it.Occurrence()
+			o.RecurrenceID = id
+			all = append(all, o)
+		}
+		return nil
+	}
+
+	events := make(map[string][]Event)
+	for _, e := range c.Events {
+		if e.RecurrenceID.Value != "" {
+			events[e.UID] = append(events[e.UID], e)
+		}
+	}
+	for i := range c.Events {
+		e := &c.Events[i]
+		if e.RecurrenceID.Value == "" {
+			if err := add(e.Occurrences(from, to, events[e.UID])); err != nil {
+				return nil, err
+			}
+			delete(events, e.UID)
+		}
+	}
+	todos := make(map[string][]Todo)
+	for _, t := range c.ToDos {
+		if t.RecurrenceID.Value != "" {
+			todos[t.UID] = append(todos[t.UID], t)
+		}
+	}
+	for i := range c.ToDos {
+		t := &c.ToDos[i]
+		if t.RecurrenceID.Value == "" {
+			if err := add(t.Occurrences(from, to, todos[t.UID])); err != nil {
+				return nil, err
+			}
+			delete(todos, t.UID)
+		}
+	}
+	// overrides without their recurring component stand for themselves
+	for i := range c.Events {
+		if e := &c.Events[i]; e.RecurrenceID.Value != "" && events[e.UID] != nil {
+			it, err := e.Occurrences(from, to, nil)
+			if err := addOrphan(e.RecurrenceID, it, err); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for i := range c.ToDos {
+		if t := &c.ToDos[i]; t.RecurrenceID.Value != "" && todos[t.UID] != nil {
+			it, err := t.Occurrences(from, to, nil)
+			if err := addOrphan(t.RecurrenceID, it, err); err != nil {
+				return nil, err
+			}
+		}
+	}
+	sort.SliceStable(all, func(i, j int) bool {
+		return all[i].Start.Before(all[j].Start)
+	})
+	return all, nil
+}
+
+func (c component) occurrences(from, to time.Time, master Occurrence) (*OccurrenceIterator, error) {
+	it := &OccurrenceIterator{
+		from:      from,
+		to:        to,
+		exdates:   make(map[int64]bool),
+		overrides: make(map[int64]bool),
+		master:    master,
+	}
+	if c.dtstart.Value == "" {
+		return it, nil
+	}
+	start, date, err := c.dtstart.Time()
+	if err != nil {
+		return nil, err
+	}
+	if it.span, err = c.span(start, date); err != nil {
+		return nil, err
+	}
+	if c.rrule != nil && c.rrule.Freq != "" {
+		if err := c.rrule.Validate(); err != nil {
+			return nil, err
+		}
+		it.rule = newExpander(c.rrule, start, date)
+	} else {
+		it.rule = &expander{start: start, done: true}
+	}
+
+	for _, l := range c.exdate {
+		loc := tzLocation(l.TZId)
+		for _, v := range l.Values {
+			t, _, err := parseDateTime(periodStart(v), loc)
+			if err != nil {
+				return nil, err
+			}
+			it.exdates[t.Unix()] = true
+		}
+	}
+	for _, l := range c.rdate {
+		loc := tzLocation(l.TZId)
+		for _, v := range l.Values {
+			t, _, err := parseDateTime(periodStart(v), loc)
+			if err != nil {
+				return nil, err
+			}
+			o := master
+			o.Start, o.RecurrenceID = t, t
+			o.End = it.span.end(t)
+			// a PERIOD gives its own end
+			if i := strings.IndexByte(v, '/'); i >= 0 {
+				if end := v[i+1:]; strings.HasPrefix(strings.TrimLeft(end, "+-"), "P") {
+					d, err := parseDuration(end)
+					if err != nil {
+						return nil, err
+					}
+					o.End = t.Add(d)
+				} else if o.End, _, err = parseDateTime(end, loc); err != nil {
+					return nil, err
+				}
+			}
+			it.extra = append(it.extra, o)
+		}
+	}
+	it.sortExtra()
+	return it, nil
+}
+
+// span returns the length of the occurrences from DTEND or DUE, DURATION or
+// the default of one day for dates and none for date-times.
+func (c component) span(start time.Time, date bool) (span, error) {
+	switch {
+	case c.end != "":
+		end, endDate, err := parseDateTime(c.end, tzLocation(c.endTZID))
+		if err != nil {
+			return span{}, err
+		}
+		if date && endDate {
+			return span{days: int(end.Sub(start).Hours()+12) / 24}, nil
+		}
+		return span{d: end.Sub(start)}, nil
+	case c.duration != "":
+		d, err := parseDuration(c.duration)
+		if err != nil {
+			return span{}, err
+		}
+		if date && d%(24*time.Hour) == 0 {
+			return span{days: int(d / (24 * time.Hour))}, nil
+		}
+		return span{d: d}, nil
+	case date:
+		return span{days: 1}, nil
+	}
+	return span{}, nil
+}
+
+// periodStart returns the start of a PERIOD value, or v if it is none.
+func periodStart(v string) string {
+	if i := strings.IndexByte(v, '/'); i >= 0 {
+		return v[:i]
+	}
+	return v
+}
+
+// override replaces the instance at the RECURRENCE-ID rid with the override.
+func (it *OccurrenceIterator) override(rid DateTimeValue, c component, o Occurrence) error {
+	id, _, err := rid.Time()
+	if err != nil {
+		return err
+	}
+	o.RecurrenceID = id
+	o.Start = id
+	date := false
+	if c.dtstart.Value != "" {
+		if o.Start, date, err = c.dtstart.Time(); err != nil {
+			return err
+		}
+	}
+	s, err := c.span(o.Start, date)
+	if err != nil {
+		return err
+	}
+	if c.end == "" && c.duration == "" && !date {
+		// without an end of its own the override keeps the length
+		s = it.span
+	}
+	o.End = s.end(o.Start)
+	it.overrides[id.Unix()] = true
+	it.extra = append(it.extra, o)
+	return nil
+}
+
+func (it *OccurrenceIterator) sortExtra() {
+	var extra []Occurrence
+	for _, o := range it.extra {
+		// overridden RDATEs are replaced by their override
+		isOverride := o.Event != it.master.Event || o.Todo != it.master.Todo
+		if !isOverride && (it.overrides[o.RecurrenceID.Unix()] || it.exdates[o.RecurrenceID.Unix()]) {
+			continue
+		}
+		extra = append(extra, o)
+	}
+	sort.SliceStable(extra, func(i, j int) bool {
+		return extra[i].Start.Before(extra[j].Start)
+	})
+	it.extra = extra
+}
+
+// Next advances to the next occurrence in the window and reports whether
+// there is one.
+func (it *OccurrenceIterator) Next() bool {
+	if it.rule == nil {
+		return false
+	}
+	for {
+		if it.pending == nil {
+			for {
+				t, ok := it.rule.next()
+				if !ok {
+					break
+				}
+				if it.exdates[t.Unix()] || it.overrides[t.Unix()] {
+					continue
+				}
+				o := it.master
+				o.Start, o.RecurrenceID, o.End = t, t, it.span.end(t)
+				it.pending = &o
+				break
+			}
+		}
+		var o Occurrence
+		switch {
+		case it.pending != nil && (len(it.extra) == 0 || !it.extra[0].Start.Before(it.pending.Start)):
+			o = *it.pending
+			it.pending = nil
+		case len(it.extra) > 0:
+			o = it.extra[0]
+			it.extra = it.extra[1:]
+		default:
+			return false
+		}
+		if !it.to.IsZero() && !o.Start.Before(it.to) {
+			it.rule.done = true
+			it.extra = nil
+			return false
+		}
+		isOverride := o.Event != it.master.Event || o.Todo != it.master.Todo
+		if !isOverride {
+			// an RDATE may repeat an instance of the rule
+			if o.RecurrenceID.Equal(it.last) {
+				continue
+			}
+			it.last = o.RecurrenceID
+		}
+		if o.End.After(it.from) || !o.Start.Before(it.from) {
+			it.cur = o
+			return true
+		}
+	}
+}
+
+// Occurrence returns the current occurrence.
+func (it *OccurrenceIterator) Occurrence() Occurrence {
+	return it.cur
+}
+
+// expander generates the instances of a recurrence rule in order. It works on
+// the wall clock of DTSTART, held as UTC times, and converts the instances
+// to the location of DTSTART.
+type expander struct {
+	r        *Recurrence
+	start    time.Time // DTSTART
+	wall     time.Time // DTSTART on the wall clock
+	loc      *time.Location
+	until    time.Time // on the wall clock
+	date     bool      // instances are dates
+	period   time.Time // start of the current period
+	interval int
+	wkst     time.Weekday
+
+	byMonthDay []int
+	byMonth    []int
+	byDay      []WeekdayNum
+	byHour     []int
+	byMinute   []int
+	bySecond   []int
+
+	buf     []time.Time
+	count   int
+	started bool
+	done    bool
+}
+
+// maxIdle is how far an expander looks ahead for an instance before deciding
+// that a rule like FREQ=YEARLY;BYMONTH=2;BYMONTHDAY=30 has none.
+const maxIdle = 400
+
+func newExpander(r *Recurrence, start time.Time, date bool) *expander {
+	e := &expander{
+		r:        r,
+		start:    start,
+		loc:      start.Location(),
+		wall:     wallClock(start),
+		date:     date,
+		interval: r.Interval,
+		wkst:     time.Monday,
+
+		byMonthDay: r.ByMonthDay,
+		byMonth:    r.ByMonth,
+		byDay:      r.ByDay,
+		byHour:     r.ByHour,
+		byMinute:   r.ByMinute,
+		bySecond:   r.BySecond,
+	}
+	if e.interval < 1 {
+		e.interval = 1
+	}
+	if r.WeekStart != 0 {
+		e.wkst = r.WeekStart.Weekday()
+	}
+	if !r.Until.IsZero() {
+		if r.UntilDate {
+			e.until = time.Date(r.Until.Year(), r.Until.Month(), r.Until.Day(), 23, 59, 59, 0, time.UTC)
+		} else if r.Until.Location() == time.Local {
+			// a floating UNTIL is in the time zone of DTSTART
+			e.until = wallClock(r.Until)
+		} else {
+			e.until = wallClock(r.Until.In(e.loc))
+		}
+	}
+
+	// the parts a rule leaves out are taken from DTSTART
+	noDay := len(r.ByWeekNo) == 0 && len(r.ByYearDay) == 0 && len(r.ByMonthDay) == 0 && len(r.ByDay) == 0
+	switch r.Freq {
+	case Yearly:
+		if noDay {
+			e.byMonthDay = []int{e.wall.Day()}
+			if len(r.ByMonth) == 0 {
+				e.byMonth = []int{int(e.wall.Month())}
+			}
+		}
+	case Monthly:
+		if noDay {
+			e.byMonthDay = []int{e.wall.Day()}
+		}
+	case Weekly:
+		if noDay {
+			e.byDay = []WeekdayNum{{Day: weekdayOf(e.wall.Weekday())}}
+		}
+	}
+	if r.Freq != Hourly && r.Freq != Minutely && r.Freq != Secondly && len(e.byHour) == 0 {
+		e.byHour = []int{e.wall.Hour()}
+	}
+	if r.Freq != Minutely && r.Freq != Secondly && len(e.byMinute) == 0 {
+		e.byMinute = []int{e.wall.Minute()}
+	}
+	if r.Freq != Secondly && len(e.bySecond) == 0 {
+		e.bySecond = []int{e.wall.Second()}
+	}
+
+	w := e.wall
+	switch r.Freq {
+	case Yearly:
+		e.period = time.Date(w.Year(), 1, 1, 0, 0, 0, 0, time.UTC)
+	case Monthly:
+		e.period = time.Date(w.Year(), w.Month(), 1, 0, 0, 0, 0, time.UTC)
+	case Weekly:
+		d := (int(w.Weekday()) - int(e.wkst) + 7) % 7
+		e.period = time.Date(w.Year(), w.Month(), w.Day()-d, 0, 0, 0, 0, time.UTC)
+	case Daily:
+		e.period = time.Date(w.Year(), w.Month(), w.Day(), 0, 0, 0, 0, time.UTC)
+	case Hourly:
+		e.period = time.Date(w.Year(), w.Month(), w.Day(), w.Hour(), 0, 0, 0, time.UTC)
+	case Minutely:
+		e.period = time.Date(w.Year(), w.Month(), w.Day(), w.Hour(), w.Minute(), 0, 0, time.UTC)
+	default:
+		e.period = w
+	}
+	return e
+}
+
+// wallClock returns the wall clock of t as a UTC time, so that calendar
+// arithmetic is not affected by daylight saving time.
+func wallClock(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), 0, time.UTC)
+}
+
+func weekdayOf(d time.Weekday) Weekday {
+	if d == time.Sunday {
+		return Sunday
+	}
+	return Weekday(d)
+}
+
+// step returns the length of a period of a rule more frequent than daily.
+func (e *expander) step() time.Duration {
+	switch e.r.Freq {
+	case Hourly:
+		return time.Duration(e.interval) * time.Hour
+	case Minutely:
+		return time.Duration(e.interval) * time.Minute
+	}
+	return time.Duration(e.interval) * time.Second
+}
+
+// next returns the next instance. DTSTART is the first one, whether or not it
+// matches the rule.
+func (e *expander) next() (time.Time, bool) {
+	if !e.started {
+		e.started = true
+		e.count = 1
+		if e.r != nil && e.r.Count == 1 {
+			e.done = true
+		}
+		return e.start, true
+	}
+	idle := e.period
+	for !e.done {
+		if len(e.buf) == 0 {
+			e.expand()
+			if len(e.buf) == 0 {
+				if e.period.Year()-idle.Year() > maxIdle || e.period.Year() > 9999 {
+					e.done = true
+				}
+				continue
+			}
+			idle = e.period
+		}
+		w := e.buf[0]
+		e.buf = e.buf[1:]
+		if !w.After(e.wall) {
+			continue
+		}
+		if !e.until.IsZero() && w.After(e.until) {
+			e.done = true
+			break
+		}
+		e.count++
+		if e.r.Count > 0 && e.count >= e.r.Count {
+			e.done = true
+		}
+		t := time.Date(w.Year(), w.Month(), w.Day(), w.Hour(), w.Minute(), w.Second(), 0, e.loc)
+		return t, true
+	}
+	return time.Time{}, false
+}
+
+// expand fills buf with the instances of the current period and advances to
+// the next period.
+func (e *expander) expand() {
+	p := e.period
+	var days []time.Time
+	switch e.r.Freq {
+	case Yearly:
+		for d := p; d.Year() == p.Year(); d = d.AddDate(0, 0, 1) {
+			days = append(days, d)
+		}
+		e.period = p.AddDate(e.interval, 0, 0)
+	case Monthly:
+		for d := p; d.Month() == p.Month(); d = d.AddDate(0, 0, 1) {
+			days = append(days, d)
+		}
+		e.period = p.AddDate(0, e.interval, 0)
+	case Weekly:
+		for i := 0; i < 7; i++ {
+			days = append(days, p.AddDate(0, 0, i))
+		}
+		e.period = p.AddDate(0, 0, 7*e.interval)
+	case Daily:
+		days = append(days, p)
+		e.period = p.AddDate(0, 0, e.interval)
+	default:
+		day := time.Date(p.Year(), p.Month(), p.Day(), 0, 0, 0, 0, time.UTC)
+		e.period = p.Add(e.step())
+		if !e.matchDay(day) {
+			// skip the rest of the day
+			if next := day.AddDate(0, 0, 1); e.period.Before(next) {
+				n := (next.Sub(e.period) + e.step() - 1) / e.step()
+				e.period = e.period.Add(n * e.step())
+			}
+			return
+		}
+		days = append(days, day)
+	}
+
+	var set []time.Time
+	for _, d := range days {
+		if !e.matchDay(d) {
+			continue
+		}
+		f := e.r.Freq
+		for _, h := range values(e.byHour, p.Hour(), f == Hourly || f == Minutely || f == Secondly) {
+			for _, m := range values(e.byMinute, p.Minute(), f == Minutely || f == Secondly) {
+				for _, s := range values(e.bySecond, p.Second(), f == Secondly) {
+					set = append(set, time.Date(d.Year(), d.Month(), d.Day(), h, m, s, 0, time.UTC))
+				}
+			}
+		}
+	}
+	sort.Slice(set, func(i, j int) bool { return set[i].Before(set[j]) })
+	if len(e.r.BySetPos) > 0 {
+		var sel []time.Time
+		for _, pos := range e.r.BySetPos {
+			i := pos - 1
+			if pos < 0 {
+				i = len(set) + pos
+			}
+			if i >= 0 && i < len(set) {
+				sel = append(sel, set[i])
+			}
+		}
+		sort.Slice(sel, func(i, j int) bool { return sel[i].Before(sel[j]) })
+		set = sel
+	}
+	if e.date {
+		for i, t := range set {
+			set[i] = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+		}
+	}
+	e.buf = set
+}
+
+// values returns the hours, minutes or seconds of a period: the BYxxx values
+// expanding a coarser period, or the one of the period if they do not limit
+// it.
+func values(by []int, cur int, limit bool) []int {
+	if !limit {
+		v := append([]int(nil), by...)
+		sort.Ints(v)
+		return v
+	}
+	if len(by) == 0 || containsInt(by, cur) {
+		return []int{cur}
+	}
+	return nil
+}
+
+func containsInt(ns []int, n int) bool {
+	for _, m := range ns {
+		if m == n {
+			return true
+		}
+	}
+	return false
+}
+
+// matchDay reports whether the day d passes the BYMONTH, BYWEEKNO,
+// BYYEARDAY, BYMONTHDAY and BYDAY parts.
+func (e *expander) matchDay(d time.Time) bool {
+	if len(e.byMonth) > 0 && !containsInt(e.byMonth, int(d.Month())) {
+		return false
+	}
+	daysInYear := time.Date(d.Year(), 12, 31, 0, 0, 0, 0, time.UTC).YearDay()
+	if len(e.r.ByYearDay) > 0 {
+		yd := d.YearDay()
+		if !containsInt(e.r.ByYearDay, yd) && !containsInt(e.r.ByYearDay, yd-daysInYear-1) {
+			return false
+		}
+	}
+	if len(e.r.ByWeekNo) > 0 {
+		week, weeks := weekNumber(d, e.wkst)
+		if !containsInt(e.r.ByWeekNo, week) && !containsInt(e.r.ByWeekNo, week-weeks-1) {
+			return false
+		}
+	}
+	daysInMonth := time.Date(d.Year(), d.Month()+1, 0, 0, 0, 0, 0, time.UTC).Day()
+	if len(e.byMonthDay) > 0 {
+		if !containsInt(e.byMonthDay, d.Day()) && !containsInt(e.byMonthDay, d.Day()-daysInMonth-1) {
+			return false
+		}
+	}
+	if len(e.byDay) == 0 {
+		return true
+	}
+	// ordinals count within the month for MONTHLY rules and YEARLY rules
+	// with BYMONTH, and within the year otherwise
+	n, last := (d.Day()-1)/7+1, -((daysInMonth-d.Day())/7 + 1)
+	if e.r.Freq == Yearly && len(e.r.ByMonth) == 0 {
+		n, last = (d.YearDay()-1)/7+1, -((daysInYear-d.YearDay())/7 + 1)
+	}
+	for _, w := range e.byDay {
+		if w.Day.Weekday() == d.Weekday() && (w.Ordinal == 0 || w.Ordinal == n || w.Ordinal == last) {
+			return true
+		}
+	}
+	return false
+}
+
+// weekNumber returns the RFC 5545 week number of d for weeks starting on
+// wkst, where week 1 is the first one with at least four days in the year,
+// and the number of weeks in that year.
+func weekNumber(d time.Time, wkst time.Weekday) (week, weeks int) {
+	number := func(d time.Time) (int, int) {
+		start := d.AddDate(0, 0, -((int(d.Weekday()) - int(wkst) + 7) % 7))
+		fourth := start.AddDate(0, 0, 3)
+		return (fourth.YearDay()-1)/7 + 1, fourth.Year()
+	}
+	week, year := number(d)
+	weeks, _ = number(time.Date(year, 12, 28, 0, 0, 0, 0, time.UTC))
+	return week, weeks
+}
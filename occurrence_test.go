@@ -0,0 +1,221 @@
+package golib_vcard
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcOccurrences expands an event at dtstart in America/New_York and returns
+// the start of up to n occurrences before to on the wall clock.
+func rfcOccurrences(t *testing.T, dtstart, rule, exdate string, n int, to time.Time) []string {
+	e := &Event{DTStart: DateTimeValue{TZId: "America/New_York", Value: dtstart}}
+	r, err := ParseRecurrence(rule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.RRule = r
+	if exdate != "" {
+		e.ExDate = []DateTimeList{{TZId: "America/New_York", Values: strings.Split(exdate, ",")}}
+	}
+	it, err := e.Occurrences(time.Time{}, to, nil)
+	if err != nil {
+		t.Fatal(rule, err)
+	}
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out []string
+	for len(out) < n && it.Next() {
+		out = append(out, it.Occurrence().Start.In(ny).Format("20060102T1504"))
+	}
+	return out
+}
+
+// TestOccurrencesRFC5545 runs the examples of RFC 5545 §3.8.5.3. want lists
+// the first occurrences of an endless rule, or the count of all of them when
+// it is a number.
+func TestOccurrencesRFC5545(t *testing.T) {
+	tests := []struct {
+		start, rule, exdate string
+		want                string
+	}{
+		{"19970902T090000", "FREQ=DAILY;COUNT=10", "", "19970902T0900 19970903T0900 19970904T0900 19970905T0900 19970906T0900 19970907T0900 19970908T0900 19970909T0900 19970910T0900 19970911T0900"},
+		{"19970902T090000", "FREQ=DAILY;UNTIL=19971224T000000Z", "", "113"},
+		{"19970902T090000", "FREQ=DAILY;INTERVAL=10;COUNT=5", "", "19970902T0900 19970912T0900 19970922T0900 19971002T0900 19971012T0900"},
+		{"19980101T090000", "FREQ=YEARLY;UNTIL=20000131T140000Z;BYMONTH=1;BYDAY=SU,MO,TU,WE,TH,FR,SA", "", "93"},
+		{"19980101T090000", "FREQ=DAILY;UNTIL=20000131T140000Z;BYMONTH=1", "", "93"},
+		{"19970902T090000", "FREQ=WEEKLY;UNTIL=19971007T000000Z;WKST=SU;BYDAY=TU,TH", "", "19970902T0900 19970904T0900 19970909T0900 19970911T0900 19970916T0900 19970918T0900 19970923T0900 19970925T0900 19970930T0900 19971002T0900"},
+		{"19970901T090000", "FREQ=WEEKLY;INTERVAL=2;UNTIL=19971224T000000Z;WKST=SU;BYDAY=MO,WE,FR", "", "19970901T0900 19970903T0900 19970905T0900 19970915T0900 19970917T0900 19970919T0900 19970929T0900 19971001T0900 19971003T0900 19971013T0900 19971015T0900 19971017T0900 19971027T0900 19971029T0900 19971031T0900 19971110T0900 19971112T0900 19971114T0900 19971124T0900 19971126T0900 19971128T0900 19971208T0900 19971210T0900 19971212T0900 19971222T0900"},
+		{"19970902T090000", "FREQ=WEEKLY;INTERVAL=2;COUNT=8;WKST=SU;BYDAY=TU,TH", "", "19970902T0900 19970904T0900 19970916T0900 19970918T0900 19970930T0900 19971002T0900 19971014T0900 19971016T0900"},
+		{"19970905T090000", "FREQ=MONTHLY;COUNT=10;BYDAY=1FR", "", "19970905T0900 19971003T0900 19971107T0900 19971205T0900 19980102T0900 19980206T0900 19980306T0900 19980403T0900 19980501T0900 19980605T0900"},
+		{"19970907T090000", "FREQ=MONTHLY;INTERVAL=2;COUNT=10;BYDAY=1SU,-1SU", "", "19970907T0900 19970928T0900 19971102T0900 19971130T0900 19980104T0900 19980125T0900 19980301T0900 19980329T0900 19980503T0900 19980531T0900"},
+		{"19970922T090000", "FREQ=MONTHLY;COUNT=6;BYDAY=-2MO", "", "19970922T0900 19971020T0900 19971117T0900 19971222T0900 19980119T0900 19980216T0900"},
+		{"19970928T090000", "FREQ=MONTHLY;BYMONTHDAY=-3", "", "19970928T0900 19971029T0900 19971128T0900 19971229T0900 19980129T0900 19980226T0900"},
+		{"19970902T090000", "FREQ=MONTHLY;COUNT=10;BYMONTHDAY=2,15", "", "19970902T0900 19970915T0900 19971002T0900 19971015T0900 19971102T0900 19971115T0900 19971202T0900 19971215T0900 19980102T0900 19980115T0900"},
+		{"19970930T090000", "FREQ=MONTHLY;COUNT=10;BYMONTHDAY=1,-1", "", "19970930T0900 19971001T0900 19971031T0900 19971101T0900 19971130T0900 19971201T0900 19971231T0900 19980101T0900 19980131T0900 19980201T0900"},
+		{"19970910T090000", "FREQ=MONTHLY;INTERVAL=18;COUNT=10;BYMONTHDAY=10,11,12,13,14,15", "", "19970910T0900 19970911T0900 19970912T0900 19970913T0900 19970914T0900 19970915T0900 19990310T0900 19990311T0900 19990312T0900 19990313T0900"},
+		{"19970902T090000", "FREQ=MONTHLY;INTERVAL=2;BYDAY=TU", "", "19970902T0900 19970909T0900 19970916T0900 19970923T0900 19970930T0900 19971104T0900 19971111T0900 19971118T0900 19971125T0900 19980106T0900"},
+		{"19970610T090000", "FREQ=YEARLY;COUNT=10;BYMONTH=6,7", "", "19970610T0900 19970710T0900 19980610T0900 19980710T0900 19990610T0900 19990710T0900 20000610T0900 20000710T0900 20010610T0900 20010710T0900"},
+		{"19970310T090000", "FREQ=YEARLY;INTERVAL=2;COUNT=10;BYMONTH=1,2,3", "", "19970310T0900 19990110T0900 19990210T0900 19990310T0900 20010110T0900 20010210T0900 20010310T0900 20030110T0900 20030210T0900 20030310T0900"},
+		{"19970101T090000", "FREQ=YEARLY;INTERVAL=3;COUNT=10;BYYEARDAY=1,100,200", "", "19970101T0900 19970410T0900 19970719T0900 20000101T0900 20000409T0900 20000718T0900 20030101T0900 20030410T0900 20030719T0900 20060101T0900"},
+		{"19970519T090000", "FREQ=YEARLY;BYDAY=20MO", "", "19970519T0900 19980518T0900 19990517T0900"},
+		{"19970512T090000", "FREQ=YEARLY;BYWEEKNO=20;BYDAY=MO", "", "19970512T0900 19980511T0900 19990517T0900"},
+		{"19970313T090000", "FREQ=YEARLY;BYMONTH=3;BYDAY=TH", "", "19970313T0900 19970320T0900 19970327T0900 19980305T0900 19980312T0900 19980319T0900 19980326T0900 19990304T0900 19990311T0900 19990318T0900 19990325T0900"},
+		{"19970605T090000", "FREQ=YEARLY;BYDAY=TH;BYMONTH=6,7,8", "", "19970605T0900 19970612T0900 19970619T0900 19970626T0900 19970703T0900 19970710T0900 19970717T0900 19970724T0900 19970731T0900 19970807T0900 19970814T0900 19970821T0900 19970828T0900 19980604T0900"},
+		{"19970902T090000", "FREQ=MONTHLY;BYDAY=FR;BYMONTHDAY=13", "19970902T090000", "19980213T0900 19980313T0900 19981113T0900 19990813T0900 20001013T0900"},
+		{"19970913T090000", "FREQ=MONTHLY;BYDAY=SA;BYMONTHDAY=7,8,9,10,11,12,13", "", "19970913T0900 19971011T0900 19971108T0900 19971213T0900 19980110T0900 19980207T0900 19980307T0900 19980411T0900 19980509T0900 19980613T0900"},
+		{"19961105T090000", "FREQ=YEARLY;INTERVAL=4;BYMONTH=11;BYDAY=TU;BYMONTHDAY=2,3,4,5,6,7,8", "", "19961105T0900 20001107T0900 20041102T0900"},
+		{"19970904T090000", "FREQ=MONTHLY;COUNT=3;BYDAY=TU,WE,TH;BYSETPOS=3", "", "19970904T0900 19971007T0900 19971106T0900"},
+		{"19970929T090000", "FREQ=MONTHLY;BYDAY=MO,TU,WE,TH,FR;BYSETPOS=-2", "", "19970929T0900 19971030T0900 19971127T0900 19971230T0900 19980129T0900 19980226T0900 19980330T0900"},
+		{"19970902T090000", "FREQ=HOURLY;INTERVAL=3;UNTIL=19970902T210000Z", "", "19970902T0900 19970902T1200 19970902T1500"},
+		{"19970902T090000", "FREQ=MINUTELY;INTERVAL=15;COUNT=6", "", "19970902T0900 19970902T0915 19970902T0930 19970902T0945 19970902T1000 19970902T1015"},
+		{"19970902T090000", "FREQ=MINUTELY;INTERVAL=90;COUNT=4", "", "19970902T0900 19970902T1030 19970902T1200 19970902T1330"},
+		{"19970902T090000", "FREQ=DAILY;BYHOUR=9,10,11,12,13,14,15,16;BYMINUTE=0,20,40", "", "19970902T0900 19970902T0920 19970902T0940 19970902T1000 19970902T1020 19970902T1040 19970902T1100 19970902T1120 19970902T1140 19970902T1200 19970902T1220 19970902T1240 19970902T1300 19970902T1320 19970902T1340 19970902T1400 19970902T1420 19970902T1440 19970902T1500 19970902T1520 19970902T1540 19970902T1600 19970902T1620 19970902T1640 19970903T0900"},
+		{"19970902T090000", "FREQ=MINUTELY;INTERVAL=20;BYHOUR=9,10,11,12,13,14,15,16", "", "19970902T0900 19970902T0920 19970902T0940 19970902T1000 19970902T1020 19970902T1040 19970902T1100 19970902T1120 19970902T1140 19970902T1200 19970902T1220 19970902T1240 19970902T1300 19970902T1320 19970902T1340 19970902T1400 19970902T1420 19970902T1440 19970902T1500 19970902T1520 19970902T1540 19970902T1600 19970902T1620 19970902T1640 19970903T0900"},
+		{"19970805T090000", "FREQ=WEEKLY;INTERVAL=2;COUNT=4;BYDAY=TU,SU;WKST=MO", "", "19970805T0900 19970810T0900 19970819T0900 19970824T0900"},
+		{"19970805T090000", "FREQ=WEEKLY;INTERVAL=2;COUNT=4;BYDAY=TU,SU;WKST=SU", "", "19970805T0900 19970817T0900 19970819T0900 19970831T0900"},
+		{"20070115T090000", "FREQ=MONTHLY;BYMONTHDAY=15,30;COUNT=5", "", "20070115T0900 20070130T0900 20070215T0900 20070315T0900 20070330T0900"},
+		// DTSTART is an instance even if the rule never matches
+		{"19970902T090000", "FREQ=YEARLY;BYMONTH=2;BYMONTHDAY=30", "", "19970902T0900"},
+		{"20000229T090000", "FREQ=YEARLY", "", "20000229T0900 20040229T0900 20080229T0900"},
+	}
+	for _, test := range tests {
+		want := strings.Fields(test.want)
+		if count, err := strconv.Atoi(test.want); err == nil {
+			if got := rfcOccurrences(t, test.start, test.rule, test.exdate, count+1, time.Time{}); len(got) != count {
+				t.Errorf("%s: got %d occurrences, want %d", test.rule, len(got), count)
+			}
+			continue
+		}
+		got := rfcOccurrences(t, test.start, test.rule, test.exdate, len(want), time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC))
+		if strings.Join(got, " ") != strings.Join(want, " ") {
+			t.Errorf("%s:\n got  %q\n want %q", test.rule, got, want)
+		}
+	}
+}
+
+const occurrenceCalendar = "BEGIN:VCALENDAR\r\n" +
+	"VERSION:2.0\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:a\r\n" +
+	"DTSTART:20240101T100000Z\r\n" +
+	"DURATION:PT1H\r\n" +
+	"RRULE:FREQ=DAILY;COUNT=3\r\n" +
+	"RDATE;VALUE=PERIOD:20240110T120000Z/PT2H\r\n" +
+	"EXDATE:20240102T100000Z\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:a\r\n" +
+	"RECURRENCE-ID:20240103T100000Z\r\n" +
+	"DTSTART:20240103T150000Z\r\n" +
+	"DTEND:20240103T153000Z\r\n" +
+	"SUMMARY:moved\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:b\r\n" +
+	"DTSTART;VALUE=DATE:20240105\r\n" +
+	"RRULE:FREQ=WEEKLY;COUNT=2\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:orphan\r\n" +
+	"RECURRENCE-ID:20240108T090000Z\r\n" +
+	"DTSTART:20240108T093000Z\r\n" +
+	"DURATION:PT15M\r\n" +
+	"SUMMARY:orphan\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VTODO\r\n" +
+	"UID:t\r\n" +
+	"DTSTART;TZID=America/New_York:20240102T090000\r\n" +
+	"DUE;TZID=Europe/Paris:20240102T200000\r\n" +
+	"END:VTODO\r\n" +
+	"END:VCALENDAR\r\n"
+
+func formatOccurrence(o Occurrence) string {
+	s := o.Start.Format("20060102T1504") + "-" + o.End.Format("20060102T1504")
+	if !o.RecurrenceID.Equal(o.Start) {
+		s += " rid " + o.RecurrenceID.Format("20060102T1504")
+	}
+	switch {
+	case o.Event != nil:
+		s += " event " + o.Event.UID
+		if o.Event.Summary != "" {
+			s += " " + o.Event.Summary
+		}
+	case o.Todo != nil:
+		s += " todo " + o.Todo.UID
+	}
+	return s
+}
+
+func TestCalendarOccurrences(t *testing.T) {
+	var c Calendar
+	if err := Unmarshal([]byte(occurrenceCalendar), &c); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		from, to time.Time
+		want     []string
+	}{
+		{"all", time.Time{}, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), []string{
+			"20240101T1000-20240101T1100 event a",
+			// DUE at 20:00 in Paris is 14:00 in New York
+			"20240102T0900-20240102T1400 todo t",
+			"20240103T1500-20240103T1530 rid 20240103T1000 event a moved",
+			"20240105T0000-20240106T0000 event b",
+			"20240108T0930-20240108T0945 rid 20240108T0900 event orphan orphan",
+			"20240110T1200-20240110T1400 event a",
+			"20240112T0000-20240113T0000 event b",
+		}},
+		{"window", time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC), time.Date(2024, 1, 6, 0, 0, 0, 0, time.UTC), []string{
+			"20240103T1500-20240103T1530 rid 20240103T1000 event a moved",
+			"20240105T0000-20240106T0000 event b",
+		}},
+		{"overlap", time.Date(2024, 1, 10, 13, 0, 0, 0, time.UTC), time.Date(2024, 1, 11, 0, 0, 0, 0, time.UTC), []string{
+			"20240110T1200-20240110T1400 event a",
+		}},
+	}
+	for _, test := range tests {
+		occ, err := c.Occurrences(test.from, test.to)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for _, o := range occ {
+			got = append(got, formatOccurrence(o))
+		}
+		if strings.Join(got, "\n") != strings.Join(test.want, "\n") {
+			t.Errorf("%s:\n got  %q\n want %q", test.name, got, test.want)
+		}
+	}
+
+	if _, err := c.Occurrences(time.Time{}, time.Time{}); err == nil {
+		t.Error("got no error without the end of the window")
+	}
+}
+
+func TestDateTimeValueJSON(t *testing.T) {
+	tests := []struct {
+		name, in string
+		want     DateTimeValue
+	}{
+		{"object", `{"Due":{"TZId":"Europe/Paris","Type":"","Value":"20240102T200000"}}`, DateTimeValue{TZId: "Europe/Paris", Value: "20240102T200000"}},
+		// earlier versions kept DUE as text
+		{"string", `{"Due":"20240102T200000"}`, DateTimeValue{Value: "20240102T200000"}},
+		{"null", `{"Due":null}`, DateTimeValue{}},
+	}
+	for _, test := range tests {
+		var td Todo
+		if err := json.Unmarshal([]byte(test.in), &td); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if td.Due != test.want {
+			t.Errorf("%s: got %+v, want %+v", test.name, td.Due, test.want)
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package golib_vcard
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"reflect"
+	"strings"
+)
+
+// ErrNotInline is returned by Photo.Bytes for a reference to external data.
+var ErrNotInline = errors.New("Not inline data")
+
+// Bytes returns the decoded data of an inline PHOTO, LOGO, SOUND or KEY,
+// given either as a data: URI (vCard 4.0) or with ENCODING=b or BASE64 (vCard
+// 2.1 and 3.0). Whitespace left over from folding and missing padding are
+// tolerated. It returns ErrNotInline for a URI reference.
+func (p *Photo) Bytes() ([]byte, error) {
+	if isDataURI(p.Data) {
+		_, data, err := parseDataURI(p.Data)
+		return data, err
+	}
+	if !p.isBase64() {
+		return nil, ErrNotInline
+	}
+	return decodeBase64(p.Data)
+}
+
+// URI returns the URI of the data if it is referenced rather than inline.
+func (p *Photo) URI() string {
+	if isDataURI(p.Data) || p.isBase64() {
+		return ""
+	}
+	return p.Data
+}
+
+// MIMEType returns the media type of the data, such as "image/jpeg". It is
+// taken from the MEDIATYPE parameter, the data: URI or the TYPE format and
+// otherwise detected from the data itself. It returns "" if it is unknown.
+func (p *Photo) MIMEType() string {
+	if p.MediaType != "" {
+		return p.MediaType
+	}
+	if isDataURI(p.Data) {
+		if mt, _, err := parseDataURI(p.Data); err == nil && mt != "" {
+			return mt
+		}
+	}
+	if mt := mediaTypeOf(p.Type); mt != "" {
+		return mt
+	}
+	if data, err := p.Bytes(); err == nil {
+		return detectMediaType(data)
+	}
+	return ""
+}
+
+// SetBytes stores data inline in the form of the given vCard version. If
+// mediaType is empty, it is detected from the data.
+func (p *Photo) SetBytes(data []byte, mediaType, version string) error {
+	if mediaType == "" {
+		mediaType = detectMediaType(data)
+	}
+	if mediaType == "" {
+		mediaType = "application/octet-stream"
+	}
+	*p = Photo{Data: "data:" + mediaType + ";base64," + base64.StdEncoding.EncodeToString(data)}
+	return p.Convert(version)
+}
+
+// SetURI stores a reference to the data at uri in the form of the given vCard
+// version. The media type is optional.
+func (p *Photo) SetURI(uri, mediaType, version string) error {
+	*p = Photo{Data: uri, MediaType: mediaType}
+	return p.Convert(version)
+}
+
+// Convert rewrites p to the form of the given vCard version: a data: URI or a
+// URI with MEDIATYPE for 4.0, ENCODING=b or VALUE=uri with a TYPE format for
+// 3.0 and ENCODING=BASE64 or VALUE=URL for 2.1.
+func (p *Photo) Convert(version string) error {
+	switch version {
+	case "2.1", "3.0", "4.0":
+	default:
+		return errors.New("Unsupported vCard version " + version)
+	}
+	cl := &ContentLine{Name: "PHOTO"}
+	if err := toContentLine(reflect.ValueOf(p), cl); err != nil {
+		if err == ErrEmpty {
+			return nil
+		}
+		return err
+	}
+	if cl.Params == nil {
+		cl.Params = make(map[string]Value)
+	}
+	if err := convertBinary(cl, version); err != nil {
+		return err
+	}
+	*p = Photo{}
+	return fromContentLine(reflect.ValueOf(p), cl, &typeInfos)
+}
+
+func (p *Photo) isBase64() bool {
+	switch strings.ToUpper(p.Encoding) {
+	case "B", "BASE64":
+		return true
+	}
+	return false
+}
+
+func isDataURI(s string) bool {
+	return len(s) >= 5 && strings.EqualFold(s[:5], "data:")
+}
+
+// signatures lists the leading bytes of the media types in mediaTypes.
+var signatures = []struct {
+	offset    int
+	magic     string
+	mediaType string
+}{
+	{0, "\xff\xd8\xff", "image/jpeg"},
+	{0, "\x89PNG\r\n\x1a\n", "image/png"},
+	{0, "GIF87a", "image/gif"},
+	{0, "GIF89a", "image/gif"},
+	{0, "BM", "image/bmp"},
+	{0, "II*\x00", "image/tiff"},
+	{0, "MM\x00*", "image/tiff"},
+	{8, "WEBP", "image/webp"},
+	{4, "ftypheic", "image/heic"},
+	{8, "WAVE", "audio/wav"},
+	{0, "ID3", "audio/mpeg"},
+	{0, "\xff\xfb", "audio/mpeg"},
+	{0, "OggS", "audio/ogg"},
+	{0, "\xff\xf1", "audio/aac"},
+	{0, "-----BEGIN PGP", "application/pgp-keys"},
+	{0, "\x30\x82", "application/pkix-cert"},
+}
+
+// detectMediaType returns the media type of data from its leading bytes, or
+// "" if it is unknown.
+func detectMediaType(data []byte) string {
+	for _, s := range signatures {
+		if len(data) >= s.offset+len(s.magic) && bytes.Equal(data[s.offset:s.offset+len(s.magic)], []byte(s.magic)) {
+			return s.mediaType
+		}
+	}
+	return ""
+}
@@ -0,0 +1,152 @@
+package golib_vcard
+
+import (
+	"strings"
+)
+
+// vcardVersions lists the vCard versions a property is defined in. Properties
+// not listed are passed through unchanged by version conversions.
+var vcardVersions = map[string]string{
+	"ADR":          "2.1 3.0 4.0",
+	"AGENT":        "2.1 3.0",
+	"ANNIVERSARY":  "4.0",
+	"BDAY":         "2.1 3.0 4.0",
+	"CALADRURI":    "4.0",
+	"CALURI":       "4.0",
+	"CATEGORIES":   "3.0 4.0",
+	"CLASS":        "3.0",
+	"CLIENTPIDMAP": "4.0",
+	"EMAIL":        "2.1 3.0 4.0",
+	"FBURL":        "4.0",
+	"FN":           "2.1 3.0 4.0",
+	"GENDER":       "4.0",
+	"GEO":          "2.1 3.0 4.0",
+	"IMPP":         "3.0 4.0",
+	"KEY":          "2.1 3.0 4.0",
+	"KIND":         "4.0",
+	"LABEL":        "2.1 3.0",
+	"LANG":         "4.0",
+	"LOGO":         "2.1 3.0 4.0",
+	"MAILER":       "2.1 3.0",
+	"MEMBER":       "4.0",
+	"N":            "2.1 3.0 4.0",
+	"NAME":         "3.0",
+	"NICKNAME":     "3.0 4.0",
+	"NOTE":         "2.1 3.0 4.0",
+	"ORG":          "2.1 3.0 4.0",
+	"PHOTO":        "2.1 3.0 4.0",
+	"PRODID":       "3.0 4.0",
+	"PROFILE":      "3.0",
+	"RELATED":      "4.0",
+	"REV":          "2.1 3.0 4.0",
+	"ROLE":         "2.1 3.0 4.0",
+	"SORT-STRING":  "3.0",
+	"SOUND":        "2.1 3.0 4.0",
+	"SOURCE":       "3.0 4.0",
+	"TEL":          "2.1 3.0 4.0",
+	"TITLE":        "2.1 3.0 4.0",
+	"TZ":           "2.1 3.0 4.0",
+	"UID":          "2.1 3.0 4.0",
+	"URL":          "2.1 3.0 4.0",
+	"VERSION":      "2.1 3.0 4.0",
+	"XML":          "4.0",
+}
+
+// uriProperties always carry a URI value.
+var uriProperties = map[string]bool{
+	"CALADRURI": true,
+	"CALURI":    true,
+	"FBURL":     true,
+	"IMPP":      true,
+	"MEMBER":    true,
+	"SOURCE":    true,
+	"URL":       true,
+}
+
+// mayBeURI lists properties whose value is a URI if it starts with a scheme.
+var mayBeURI = map[string]bool{
+	"GEO":     true,
+	"KEY":     true,
+	"LOGO":    true,
+	"PHOTO":   true,
+	"RELATED": true,
+	"SOUND":   true,
+	"TEL":     true,
+	"UID":     true,
+}
+
+// isURIValue reports whether the value of a property is a URI, which is
+// neither split at commas and semicolons nor escaped.
+func isURIValue(name string, params map[string]Value, value string) bool {
+	if _, v := paramValue(params, "VALUE"); v != "" {
+		switch strings.ToUpper(v) {
+		case "URI", "URL":
+			return true
+		}
+		return false
+	}
+	name = strings.ToUpper(name)
+	return uriProperties[name] || mayBeURI[name] && hasScheme(value)
+}
+
+// hasScheme reports whether s starts with a URI scheme such as "data:".
+func hasScheme(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z':
+		case i > 0 && ('0' <= c && c <= '9' || c == '+' || c == '-' || c == '.'):
+		case i > 1 && c == ':':
+			return true
+		default:
+			return false
+		}
+	}
+	return false
+}
+
+// mediaTypes maps the vCard 2.1 and 3.0 TYPE formats of binary properties to
+// MIME media types.
+var mediaTypes = map[string]string{
+	"JPEG": "image/jpeg",
+	"JPG":  "image/jpeg",
+	"PNG":  "image/png",
+	"GIF":  "image/gif",
+	"BMP":  "image/bmp",
+	"TIFF": "image/tiff",
+	"WEBP": "image/webp",
+	"HEIC": "image/heic",
+	"WAVE": "audio/wav",
+	"WAV":  "audio/wav",
+	"MP3":  "audio/mpeg",
+	"OGG":  "audio/ogg",
+	"AAC":  "audio/aac",
+	"PGP":  "application/pgp-keys",
+	"X509": "application/pkix-cert",
+}
+
+// mediaTypeOf returns the media type of a TYPE format, or "" if format is
+// not a known format.
+func mediaTypeOf(format string) string {
+	return mediaTypes[strings.ToUpper(format)]
+}
+
+// formatOf returns the TYPE format of a media type, for example JPEG for
+// image/jpeg.
+func formatOf(mediaType string) string {
+	mediaType = strings.ToLower(mediaType)
+	best := ""
+	for f, mt := range mediaTypes {
+		// prefer the longest and then alphabetically first name, so
+		// image/jpeg maps to JPEG rather than JPG
+		if mt == mediaType && (best == "" || len(f) > len(best) || len(f) == len(best) && f < best) {
+			best = f
+		}
+	}
+	if best == "" {
+		if i := strings.IndexByte(mediaType, '/'); i >= 0 {
+			return strings.ToUpper(mediaType[i+1:])
+		}
+	}
+	return best
+}
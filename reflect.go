@@ -3,13 +3,78 @@ package golib_vcard
 import (
 	"errors"
 	"reflect"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 var (
 	ErrEmpty = errors.New("Empty")
 )
 
+// Marshaler is implemented by types that encode themselves as a property.
+// The name of the returned content line is replaced by the one of the struct
+// field; a nil line or ErrEmpty omits the property.
+type Marshaler interface {
+	MarshalVdir() (*ContentLine, error)
+}
+
+// Unmarshaler is implemented by types that decode themselves from a property.
+type Unmarshaler interface {
+	UnmarshalVdir(cl *ContentLine) error
+}
+
+// ObjectMarshaler is implemented by types that encode themselves as an
+// object, for example a component. A nil object or ErrEmpty omits it.
+type ObjectMarshaler interface {
+	MarshalVdirObject() (*Object, error)
+}
+
+// ObjectUnmarshaler is implemented by types that decode themselves from an
+// object.
+type ObjectUnmarshaler interface {
+	UnmarshalVdirObject(o *Object) error
+}
+
+var (
+	marshalerType         = reflect.TypeOf((*Marshaler)(nil)).Elem()
+	unmarshalerType       = reflect.TypeOf((*Unmarshaler)(nil)).Elem()
+	objectMarshalerType   = reflect.TypeOf((*ObjectMarshaler)(nil)).Elem()
+	objectUnmarshalerType = reflect.TypeOf((*ObjectUnmarshaler)(nil)).Elem()
+)
+
+// implementer returns rv, or its address if only the pointer implements
+// iface, as an interface value. ok is false if neither implements iface;
+// a nil pointer yields a nil value.
+func implementer(rv reflect.Value, iface reflect.Type) (v interface{}, ok bool) {
+	if !rv.IsValid() {
+		return nil, false
+	}
+	if rv.Kind() != reflect.Ptr && rv.CanAddr() && reflect.PtrTo(rv.Type()).Implements(iface) {
+		rv = rv.Addr()
+	}
+	if !rv.Type().Implements(iface) {
+		return nil, false
+	}
+	if rv.Kind() == reflect.Ptr && rv.IsNil() {
+		return nil, true
+	}
+	return rv.Interface(), true
+}
+
+// implements reports whether typ or a pointer to it implements any of the
+// interfaces.
+func implements(typ reflect.Type, ifaces ...reflect.Type) bool {
+	for _, iface := range ifaces {
+		if typ.Implements(iface) || (typ.Kind() != reflect.Ptr && reflect.PtrTo(typ).Implements(iface)) {
+			return true
+		}
+	}
+	return false
+}
+
 // ToObject converts a struct v into an intermediate Object that
 // can be written by an encoder.
 //
@@ -19,22 +84,49 @@ func ToObject(v interface{}, o *Object) error {
 	if v == nil {
 		return errors.New("Can not marshal nil value.")
 	}
-	rv := reflect.ValueOf(v)
+	return toObject(reflect.ValueOf(v), o)
+}
 
+func toObject(rv reflect.Value, o *Object) error {
+	if m, ok := implementer(rv, objectMarshalerType); ok {
+		if m == nil {
+			return ErrEmpty
+		}
+		obj, err := m.(ObjectMarshaler).MarshalVdirObject()
+		if err != nil {
+			return err
+		}
+		if obj == nil {
+			return ErrEmpty
+		}
+		*o = *obj
+		return nil
+	}
 	if rv.Kind() == reflect.Ptr {
-		rv = reflect.Indirect(rv)
+		if rv.IsNil() {
+			return ErrEmpty
+		}
+		rv = rv.Elem()
 	}
 
 	switch rv.Kind() {
 	case reflect.Struct:
 		typ := rv.Type()
-		for i := 0; i < typ.NumField(); i++ {
-			rvi := rv.Field(i)
-			name, opt := fieldToProp(typ.Field(i))
-			if name == "-" {
+		ti, err := getTypeInfo(typ)
+		if err != nil {
+			return err
+		}
+		var extra []reflect.Value
+		var ungrouped [][]*ContentLine
+		for _, f := range ti.fields {
+			rvi, ok := fieldByIndex(rv, f.index, false)
+			if !ok {
 				continue
 			}
-			switch opt {
+			name, opts := f.name, f.opts
+			switch opts.kind {
+			case "extra":
+				extra = append(extra, rvi)
 			case "profile":
 				if v := rvi.String(); v != "" {
 					o.Profile = v
@@ -42,48 +134,66 @@ func ToObject(v interface{}, o *Object) error {
 					o.Profile = name
 				}
 			case "object":
+				n := len(o.Objects)
 				if rvi.Kind() == reflect.Slice {
-					if rvi.IsNil() {
-						continue
-					}
 					for j := 0; j < rvi.Len(); j++ {
 						comp := &Object{}
-						if err := ToObject(rvi.Index(j), comp); err != nil {
+						if err := toObject(rvi.Index(j), comp); err != nil {
+							if err == ErrEmpty {
+								continue
+							}
 							return err
 						}
+						if comp.Profile == "" {
+							comp.Profile = name
+						}
 						o.Objects = append(o.Objects, comp)
 					}
 				} else {
 					comp := &Object{}
-					if err := ToObject(rvi, comp); err != nil {
-						return err
+					if err := toObject(rvi, comp); err != nil {
+						if err != ErrEmpty {
+							return err
+						}
+					} else {
+						if comp.Profile == "" {
+							comp.Profile = name
+						}
+						o.Objects = append(o.Objects, comp)
 					}
-					o.Objects = append(o.Objects, comp)
+				}
+				if opts.required && len(o.Objects) == n {
+					return errors.New("Missing required object " + name)
 				}
 			default:
-				if rvi.Kind() == reflect.Slice && rvi.Type().Elem().Kind() != reflect.String {
+				n := len(o.Properties)
+				if rvi.Kind() == reflect.Slice && (opts.multiple || !isScalar(rvi.Type().Elem())) {
 					for j := 0; j < rvi.Len(); j++ {
-						cl := &ContentLine{Name: name}
-						if err := toContentLine(rvi.Index(j), cl); err != nil {
-							if err == ErrEmpty {
-								continue
-							}
+						if err := appendContentLine(o, rvi.Index(j), name, opts, &ungrouped); err != nil {
 							return err
 						}
-						o.Properties = append(o.Properties, cl)
-					}
-				} else {
-					cl := &ContentLine{Name: name}
-					if err := toContentLine(rv.Field(i), cl); err != nil {
-						if err == ErrEmpty {
-							continue
-						}
-						return err
 					}
-					o.Properties = append(o.Properties, cl)
+				} else if err := appendContentLine(o, rvi, name, opts, &ungrouped); err != nil {
+					return err
+				}
+				if opts.required && len(o.Properties) == n {
+					return errors.New("Missing required property " + name)
 				}
 			}
 		}
+		for _, rvi := range extra {
+			if err := extraToObject(rvi, o); err != nil {
+				return err
+			}
+		}
+		// groups are generated last so they do not clash with extra properties
+		n := lastItemGroup(o)
+		for _, cls := range ungrouped {
+			n++
+			for _, cl := range cls {
+				cl.Group = "item" + strconv.Itoa(n)
+			}
+		}
 	default:
 		return errors.New("Cannot marshal " + rv.Type().String() + " into object")
 	}
@@ -91,75 +201,593 @@ func ToObject(v interface{}, o *Object) error {
 	return nil
 }
 
+// appendContentLine adds the property for rv to o unless it is empty. The
+// properties of its ",grouped" fields follow it; if they have no group, they
+// are added to ungrouped to be given one.
+func appendContentLine(o *Object, rv reflect.Value, name string, opts tagOptions, ungrouped *[][]*ContentLine) error {
+	cl := &ContentLine{Group: opts.group, Name: name}
+	if err := toContentLine(rv, cl); err != nil {
+		if err == ErrEmpty {
+			return nil
+		}
+		return err
+	}
+	if opts.omitempty && isEmptyValue(cl.Value) {
+		return nil
+	}
+	grouped, err := groupedContentLines(rv)
+	if err != nil {
+		return err
+	}
+	o.Properties = append(o.Properties, cl)
+	for _, gcl := range grouped {
+		gcl.Group = cl.Group
+		o.Properties = append(o.Properties, gcl)
+	}
+	if len(grouped) > 0 && cl.Group == "" {
+		*ungrouped = append(*ungrouped, append([]*ContentLine{cl}, grouped...))
+	}
+	return nil
+}
+
+// groupedContentLines returns the properties for the ",grouped" fields of the
+// struct rv, which are written in the group of the property for rv.
+func groupedContentLines(rv reflect.Value) ([]*ContentLine, error) {
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return nil, nil
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct || rv.Type() == timeType || implements(rv.Type(), marshalerType) {
+		return nil, nil
+	}
+	ti, err := getTypeInfo(rv.Type())
+	if err != nil {
+		return nil, err
+	}
+	var cls []*ContentLine
+	for _, f := range ti.fields {
+		if f.opts.kind != "grouped" {
+			continue
+		}
+		rvi, ok := fieldByIndex(rv, f.index, false)
+		if !ok {
+			continue
+		}
+		cl := &ContentLine{Name: f.name}
+		if err := toContentLine(rvi, cl); err != nil {
+			if err == ErrEmpty {
+				continue
+			}
+			return nil, err
+		}
+		cls = append(cls, cl)
+	}
+	return cls, nil
+}
+
+// lastItemGroup returns the highest N of the groups "itemN" in o, the names
+// Apple Contacts gives the groups that bind properties to their labels.
+func lastItemGroup(o *Object) int {
+	n := 0
+	for _, cl := range o.Properties {
+		if len(cl.Group) > 4 && strings.EqualFold(cl.Group[:4], "item") {
+			if i, err := strconv.Atoi(cl.Group[4:]); err == nil && i > n {
+				n = i
+			}
+		}
+	}
+	return n
+}
+
+var (
+	contentLinesType = reflect.TypeOf([]*ContentLine{})
+	contentLineMap   = reflect.TypeOf(map[string][]*ContentLine{})
+	objectsType      = reflect.TypeOf([]*Object{})
+	paramsType       = reflect.TypeOf(map[string]Value{})
+)
+
+// extraToObject appends the properties and objects held by a field tagged
+// ",extra" to o.
+func extraToObject(rv reflect.Value, o *Object) error {
+	switch rv.Type() {
+	case contentLinesType:
+		for _, cl := range rv.Interface().([]*ContentLine) {
+			if cl != nil {
+				o.Properties = append(o.Properties, cl)
+			}
+		}
+	case contentLineMap:
+		m := rv.Interface().(map[string][]*ContentLine)
+		names := make([]string, 0, len(m))
+		for name := range m {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			for _, cl := range m[name] {
+				if cl != nil {
+					o.Properties = append(o.Properties, cl)
+				}
+			}
+		}
+	case objectsType:
+		for _, so := range rv.Interface().([]*Object) {
+			if so != nil {
+				o.Objects = append(o.Objects, so)
+			}
+		}
+	default:
+		return errors.New("Cannot use " + rv.Type().String() + " as extra field")
+	}
+	return nil
+}
+
+// objectProfile returns the profile an ",object" field without a name maps
+// to, which is the name of the ",profile" field of its element type.
+func objectProfile(typ reflect.Type) string {
+	for typ.Kind() == reflect.Ptr || typ.Kind() == reflect.Slice {
+		typ = typ.Elem()
+	}
+	if typ.Kind() != reflect.Struct {
+		return ""
+	}
+	for i := 0; i < typ.NumField(); i++ {
+		if name, opts, err := parseTag(typ.Field(i)); err == nil && opts.kind == "profile" {
+			return name
+		}
+	}
+	return ""
+}
+
 func toContentLine(rv reflect.Value, cl *ContentLine) error {
-	if rv.Kind() == reflect.Ptr {
-		rv = reflect.Indirect(rv)
+	if m, ok := implementer(rv, marshalerType); ok {
+		if m == nil {
+			return ErrEmpty
+		}
+		line, err := m.(Marshaler).MarshalVdir()
+		if err != nil {
+			return err
+		}
+		if line == nil {
+			return ErrEmpty
+		}
+		cl.Group, cl.Params, cl.Value = line.Group, line.Params, line.Value
+		return nil
+	}
+	if rv.Kind() == reflect.Ptr && !isScalar(rv.Type()) {
+		if rv.IsNil() {
+			return ErrEmpty
+		}
+		rv = rv.Elem()
 	}
 
-	switch rv.Kind() {
-	case reflect.Struct:
+	switch {
+	case rv.Kind() == reflect.Struct && rv.Type() != timeType:
 		typ := rv.Type()
-		for i := 0; i < typ.NumField(); i++ {
-			name, opt := fieldToProp(typ.Field(i))
-			if name == "-" {
+		ti, err := getTypeInfo(typ)
+		if err != nil {
+			return err
+		}
+		var values []fieldInfo
+		for _, f := range ti.fields {
+			name, opts := f.name, f.opts
+			rvi, ok := fieldByIndex(rv, f.index, false)
+			if !ok {
 				continue
 			}
-			v, err := toValue(rv.Field(i))
+			if opts.kind == "extra" {
+				if rvi.Type() != paramsType {
+					return errors.New("Cannot use " + rvi.Type().String() + " as extra parameters")
+				}
+				for k, v := range rvi.Interface().(map[string]Value) {
+					if cl.Params == nil {
+						cl.Params = make(map[string]Value)
+					}
+					if _, ok := cl.Params[k]; !ok {
+						cl.Params[k] = v
+					}
+				}
+				continue
+			}
+			if opts.kind == "group" {
+				if rvi.Kind() != reflect.String {
+					return errors.New("Cannot use " + rvi.Type().String() + " as group")
+				}
+				if g := rvi.String(); g != "" {
+					cl.Group = g
+				}
+				continue
+			}
+			if opts.kind == "grouped" {
+				continue
+			}
+			if opts.kind != "param" {
+				values = append(values, f)
+				continue
+			}
+			v, err := toValue(rvi, false)
 			if err != nil {
 				return err
 			}
-			if opt == "param" {
-				if len(v) == 0 || v[0] == "" {
-					continue
-				}
-				if cl.Params == nil {
-					cl.Params = make(map[string]Value)
+			if len(v) == 0 || v[0] == "" {
+				if opts.required {
+					return errors.New("Missing required parameter " + name + " of " + cl.Name)
 				}
-				cl.Params[name] = v
-			} else {
-				cl.Value = append(cl.Value, v)
+				continue
+			}
+			if cl.Params == nil {
+				cl.Params = make(map[string]Value)
+			}
+			cl.Params[name] = v
+		}
+		// parameters come first as VALUE=DATE decides how times are written
+		date := isDateValued(cl)
+		for _, f := range values {
+			rvi, _ := fieldByIndex(rv, f.index, false)
+			v, err := toValue(rvi, date)
+			if err != nil {
+				return err
 			}
+			if f.opts.required && isEmptyValue(StructuredValue{v}) {
+				return errors.New("Missing required " + f.field + " value of " + cl.Name)
+			}
+			setTimeZone(cl, rvi)
+			cl.Value = append(cl.Value, v)
 		}
 	default:
-		v, err := toValue(rv)
+		v, err := toValue(rv, isDateValued(cl))
 		if err != nil {
 			return err
 		}
 		if len(v) == 0 || v[0] == "" {
 			return ErrEmpty
 		}
+		setTimeZone(cl, rv)
 		cl.Value = StructuredValue{v}
 	}
 	return nil
 }
 
-func toValue(rv reflect.Value) (Value, error) {
-	switch rv.Kind() {
-	case reflect.String:
-		return Value{rv.String()}, nil
-	case reflect.Slice:
-		if rv.Type().Elem().Kind() != reflect.String {
-			return nil, errors.New("Cannot marshal " + rv.Type().String() + " into value")
+// isEmptyValue reports whether all components of sv are empty.
+func isEmptyValue(sv StructuredValue) bool {
+	for _, v := range sv {
+		for _, vv := range v {
+			if vv != "" {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	durationType = reflect.TypeOf(time.Duration(0))
+)
+
+// isScalar reports whether a Go value of type typ maps to a single value, as
+// opposed to a structured property or a list of properties.
+func isScalar(typ reflect.Type) bool {
+	if implements(typ, marshalerType, unmarshalerType) {
+		return false
+	}
+	if typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	switch typ.Kind() {
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return typ == timeType
+}
+
+// isDateValued reports whether times in cl are written as a DATE when they
+// fall on midnight, which holds for VALUE=DATE and the vCard date properties
+// like BDAY.
+func isDateValued(cl *ContentLine) bool {
+	if k, v := paramValue(cl.Params, "VALUE"); k != "" {
+		return strings.EqualFold(v, "DATE")
+	}
+	return jcard.defaultType(strings.ToUpper(cl.Name)) == "date-and-or-time"
+}
+
+// setTimeZone adds a TZID parameter for a time rv in a named location unless
+// the property already has one.
+func setTimeZone(cl *ContentLine, rv reflect.Value) {
+	if rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return
 		}
+		rv = rv.Elem()
+	}
+	if rv.Type() != timeType {
+		return
+	}
+	loc := rv.Interface().(time.Time).Location()
+	if loc == time.UTC || loc == time.Local || loc.String() == "" || isDateValued(cl) {
+		return
+	}
+	if k, _ := paramValue(cl.Params, "TZID"); k != "" {
+		return
+	}
+	if cl.Params == nil {
+		cl.Params = make(map[string]Value)
+	}
+	cl.Params["TZID"] = Value{loc.String()}
+}
+
+// toValue converts a scalar or a slice of scalars. Zero values become an
+// empty value unless they are pointed to. Midnight times are written as a
+// DATE if date is set.
+func toValue(rv reflect.Value, date bool) (Value, error) {
+	if rv.Kind() == reflect.Slice && isScalar(rv.Type().Elem()) {
 		v := Value{}
 		for i := 0; i < rv.Len(); i++ {
-			v = append(v, rv.Index(i).String())
+			s, err := formatScalar(rv.Index(i), date, true)
+			if err != nil {
+				return nil, err
+			}
+			v = append(v, s)
 		}
 		return v, nil
 	}
-	return nil, errors.New("Cannot marshal " + rv.Type().String() + " into value")
+	s, err := formatScalar(rv, date, false)
+	if err != nil {
+		return nil, err
+	}
+	return Value{s}, nil
 }
 
-func fieldToProp(f reflect.StructField) (name, options string) {
+func formatScalar(rv reflect.Value, date, keepZero bool) (string, error) {
+	if rv.Kind() == reflect.Ptr && isScalar(rv.Type()) {
+		if rv.IsNil() {
+			return "", nil
+		}
+		rv, keepZero = rv.Elem(), true
+	}
+	if !keepZero && rv.Kind() != reflect.String && rv.IsZero() {
+		return "", nil
+	}
+	switch {
+	case rv.Type() == timeType:
+		t := rv.Interface().(time.Time)
+		midnight := t.Hour() == 0 && t.Minute() == 0 && t.Second() == 0 && t.Nanosecond() == 0
+		return formatDateTime(t, date && midnight), nil
+	case rv.Type() == durationType:
+		return formatDuration(time.Duration(rv.Int())), nil
+	}
+	switch rv.Kind() {
+	case reflect.String:
+		return rv.String(), nil
+	case reflect.Bool:
+		return strings.ToUpper(strconv.FormatBool(rv.Bool())), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(rv.Int(), 10), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(rv.Uint(), 10), nil
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(rv.Float(), 'f', -1, rv.Type().Bits()), nil
+	}
+	return "", errors.New("Cannot marshal " + rv.Type().String() + " into value")
+}
+
+// tagOptions holds the options of a vdir struct tag after the name.
+type tagOptions struct {
+	kind      string // "profile", "object", "param", "extra", "group", "grouped" or "" for properties
+	unnamed   bool   // the tag gives no name
+	omitempty bool   // omit properties without values even if they have parameters
+	required  bool   // fail if the property, object or parameter is missing
+	multiple  bool   // write a slice as one property per element
+	group     string // the group of the property
+}
+
+// parseTag returns the upper-case property name and the options of the vdir
+// tag of f, such as `vdir:"tel,omitempty,group=item1"`. The name defaults to
+// the field name.
+func parseTag(f reflect.StructField) (name string, opts tagOptions, err error) {
 	tag := strings.Split(f.Tag.Get("vdir"), ",")
 	name = tag[0]
-	if len(tag) > 1 {
-		options = tag[1]
-	}
 	if name == "" {
 		name = f.Name
+		opts.unnamed = true
 	}
-	return strings.ToUpper(name), options
+	for _, opt := range tag[1:] {
+		switch {
+		case opt == "profile" || opt == "object" || opt == "param" || opt == "extra" ||
+			opt == "group" || opt == "grouped":
+			if opts.kind != "" {
+				return "", opts, errors.New("Conflicting vdir options " + opts.kind + " and " + opt + " on field " + f.Name)
+			}
+			opts.kind = opt
+		case opt == "omitempty":
+			opts.omitempty = true
+		case opt == "required":
+			opts.required = true
+		case opt == "multiple":
+			if f.Type.Kind() != reflect.Slice {
+				return "", opts, errors.New("Cannot use vdir option multiple on non-slice field " + f.Name)
+			}
+			opts.multiple = true
+		case strings.HasPrefix(opt, "group="):
+			opts.group = opt[len("group="):]
+		case opt == "":
+		default:
+			return "", opts, errors.New("Unknown vdir option " + strconv.Quote(opt) + " on field " + f.Name)
+		}
+	}
+	return strings.ToUpper(name), opts, nil
+}
+
+// fieldInfo describes a struct field mapped by its vdir tag.
+type fieldInfo struct {
+	index []int // index sequence for reflect.Value.FieldByIndex
+	field string
+	name  string
+	opts  tagOptions
+}
+
+// typeInfo holds the mapped fields of a struct type. byName indexes the
+// property fields by name.
+type typeInfo struct {
+	fields []fieldInfo
+	byName map[string][]int
+	err    error
+}
+
+// typeInfos caches the typeInfo of each struct type, keyed by reflect.Type.
+var typeInfos sync.Map
+
+// getTypeInfo returns the typeInfo of the struct type typ, parsing its tags on
+// first use.
+func getTypeInfo(typ reflect.Type) (*typeInfo, error) {
+	return cachedTypeInfo(&typeInfos, typ)
+}
+
+// cachedTypeInfo is getTypeInfo with the cache given by types.
+func cachedTypeInfo(types *sync.Map, typ reflect.Type) (*typeInfo, error) {
+	if ti, ok := types.Load(typ); ok {
+		return ti.(*typeInfo), ti.(*typeInfo).err
+	}
+	ti := &typeInfo{byName: make(map[string][]int)}
+	ti.fields, ti.err = typeFields(typ)
+	for i, f := range ti.fields {
+		if f.opts.kind == "" {
+			ti.byName[f.name] = append(ti.byName[f.name], i)
+		}
+	}
+	v, _ := types.LoadOrStore(typ, ti)
+	return v.(*typeInfo), ti.err
+}
+
+// typeFields lists the mapped fields of typ. The fields of untagged embedded
+// structs are promoted as encoding/json does: a field hides the fields of the
+// same kind and name that are nested more deeply. Of several embedded fields
+// at the same depth, the one whose tag gives the name wins; if there is no
+// such single field, all of them are dropped. Fields of typ itself are all
+// kept. An unnamed object field takes the profile of its element type as
+// name.
+func typeFields(typ reflect.Type) ([]fieldInfo, error) {
+	type entry struct {
+		fieldInfo
+		depth int
+	}
+	var entries []entry
+	var walk func(typ reflect.Type, index []int, visited map[reflect.Type]bool) error
+	walk = func(typ reflect.Type, index []int, visited map[reflect.Type]bool) error {
+		for i := 0; i < typ.NumField(); i++ {
+			sf := typ.Field(i)
+			idx := append(append([]int(nil), index...), i)
+			if sf.Anonymous && sf.Tag.Get("vdir") == "" {
+				et := sf.Type
+				if et.Kind() == reflect.Ptr {
+					et = et.Elem()
+				}
+				if et.Kind() == reflect.Struct && et != timeType && !implements(sf.Type, marshalerType, unmarshalerType) {
+					if visited[et] || (sf.PkgPath != "" && sf.Type.Kind() == reflect.Ptr) {
+						continue
+					}
+					visited[et] = true
+					err := walk(et, idx, visited)
+					delete(visited, et)
+					if err != nil {
+						return err
+					}
+					continue
+				}
+			}
+			if sf.PkgPath != "" {
+				continue
+			}
+			name, opts, err := parseTag(sf)
+			if err != nil {
+				return err
+			}
+			if name == "-" {
+				continue
+			}
+			if opts.kind == "object" && opts.unnamed {
+				if profile := objectProfile(sf.Type); profile != "" {
+					name = profile
+				}
+			}
+			entries = append(entries, entry{fieldInfo{idx, sf.Name, name, opts}, len(index)})
+		}
+		return nil
+	}
+	if err := walk(typ, nil, map[reflect.Type]bool{typ: true}); err != nil {
+		return nil, err
+	}
+
+	// shallowest holds the entries of each kind and name at the least depth
+	shallowest := make(map[string][]int)
+	key := func(f fieldInfo) string {
+		return f.opts.kind + ":" + f.name
+	}
+	for i, e := range entries {
+		k := key(e.fieldInfo)
+		if s := shallowest[k]; len(s) == 0 || e.depth < entries[s[0]].depth {
+			shallowest[k] = []int{i}
+		} else if e.depth == entries[s[0]].depth {
+			shallowest[k] = append(s, i)
+		}
+	}
+	// tagged returns the only entry of s whose tag gives a name, or -1
+	tagged := func(s []int) int {
+		t := -1
+		for _, j := range s {
+			if !entries[j].opts.unnamed {
+				if t >= 0 {
+					return -1
+				}
+				t = j
+			}
+		}
+		return t
+	}
+	var fields []fieldInfo
+	for i, e := range entries {
+		s := shallowest[key(e.fieldInfo)]
+		switch {
+		case e.depth != entries[s[0]].depth:
+			// hidden by a shallower field
+		case e.depth == 0 || len(s) == 1 || tagged(s) == i:
+			fields = append(fields, e.fieldInfo)
+		}
+	}
+	return fields, nil
+}
+
+// fieldByIndex returns the nested field of the struct rv at index. Nil
+// embedded pointers are allocated if alloc is set; otherwise ok is false.
+func fieldByIndex(rv reflect.Value, index []int, alloc bool) (f reflect.Value, ok bool) {
+	for i, x := range index {
+		if i > 0 && rv.Kind() == reflect.Ptr {
+			if rv.IsNil() {
+				if !alloc {
+					return reflect.Value{}, false
+				}
+				rv.Set(reflect.New(rv.Type().Elem()))
+			}
+			rv = rv.Elem()
+		}
+		rv = rv.Field(x)
+	}
+	return rv, true
+}
+
+// isUpper reports whether s has no lower-case ASCII letters.
+func isUpper(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if 'a' <= s[i] && s[i] <= 'z' {
+			return false
+		}
+	}
+	return true
 }
 
 // FromObject converts an intermediate Object into a struct.
@@ -167,6 +795,11 @@ func fieldToProp(f reflect.StructField) (name, options string) {
 // See the documentation for Unmarshal for details about the conversion of into
 // a Go Value.
 func FromObject(v interface{}, o *Object) error {
+	return fromObject(v, o, &typeInfos)
+}
+
+// fromObject is FromObject with the typeInfo cache given by types.
+func fromObject(v interface{}, o *Object, types *sync.Map) error {
 	rv := reflect.ValueOf(v)
 
 	if rv.Kind() != reflect.Ptr {
@@ -175,99 +808,306 @@ func FromObject(v interface{}, o *Object) error {
 	if rv.IsNil() {
 		rv.Set(reflect.New(rv.Type().Elem()))
 	}
+	for rv.Elem().Kind() == reflect.Ptr {
+		if rv.Elem().IsNil() {
+			rv.Elem().Set(reflect.New(rv.Elem().Type().Elem()))
+		}
+		rv = rv.Elem()
+	}
+	if u, ok := rv.Interface().(ObjectUnmarshaler); ok {
+		return u.UnmarshalVdirObject(o)
+	}
 
-	props := o.PropertyMap()
 	switch rv.Elem().Kind() {
 	case reflect.Struct:
-		typ := rv.Type()
-		for i := 0; i < typ.Elem().NumField(); i++ {
-			rvi := rv.Elem().Field(i)
-			name, opt := fieldToProp(typ.Elem().Field(i))
-			if name == "-" {
-				continue
+		ti, err := cachedTypeInfo(types, rv.Type().Elem())
+		if err != nil {
+			return err
+		}
+		// sort the properties by field in a single pass
+		props := make([][]*ContentLine, len(ti.fields))
+		for _, cl := range o.Properties {
+			name := cl.Name
+			if !isUpper(name) {
+				name = strings.ToUpper(name)
+			}
+			for _, fi := range ti.byName[name] {
+				props[fi] = append(props[fi], cl)
 			}
-			switch opt {
+		}
+		used := make(map[*ContentLine]bool)
+		usedObjects := make(map[*Object]bool)
+		var extra []reflect.Value
+		for fi, f := range ti.fields {
+			name, opts := f.name, f.opts
+			switch opts.kind {
+			case "extra":
+				rvi, _ := fieldByIndex(rv.Elem(), f.index, true)
+				extra = append(extra, rvi)
 			case "profile":
+				rvi, _ := fieldByIndex(rv.Elem(), f.index, true)
 				if rvi.Kind() != reflect.String {
 					return errors.New("Cannot unmarshal profile into " + rvi.Type().String())
 				}
 				rvi.SetString(o.Profile)
 			case "object":
-				if rvi.Kind() != reflect.Slice {
-					return errors.New("Cannot unmarshal object into " + rv.Type().String())
-				}
+				var objects []*Object
 				for _, so := range o.Objects {
-					if so.Profile != name {
-						continue
+					if so.Profile == name {
+						objects = append(objects, so)
 					}
+				}
+				if len(objects) == 0 {
+					if opts.required {
+						return errors.New("Missing required object " + name)
+					}
+					continue
+				}
+				// embedded pointers are only allocated for fields that are set
+				rvi, _ := fieldByIndex(rv.Elem(), f.index, true)
+				if rvi.Kind() != reflect.Slice {
+					// a single object takes the first matching component
+					usedObjects[objects[0]] = true
+					if err := fromObject(rvi.Addr().Interface(), objects[0], types); err != nil {
+						return err
+					}
+					continue
+				}
+				for _, so := range objects {
+					usedObjects[so] = true
 					rvii := reflect.New(rvi.Type().Elem())
-					if err := FromObject(rvii.Interface(), so); err != nil {
+					if err := fromObject(rvii.Interface(), so, types); err != nil {
 						return err
 					}
 					rvi.Set(reflect.Append(rvi, reflect.Indirect(rvii)))
 				}
 			default:
-				cls := props[name]
-				if cls == nil || len(cls) == 0 {
+				var cls []*ContentLine
+				for _, cl := range props[fi] {
+					if opts.group == "" || strings.EqualFold(cl.Group, opts.group) {
+						cls = append(cls, cl)
+					}
+				}
+				if len(cls) == 0 {
+					if opts.required {
+						return errors.New("Missing required property " + name)
+					}
 					continue
 				}
-				if rvi.Kind() == reflect.Slice && rvi.Type().Elem().Kind() != reflect.String {
+				rvi, _ := fieldByIndex(rv.Elem(), f.index, true)
+				switch {
+				case rvi.Kind() == reflect.Slice && !isScalar(rvi.Type().Elem()):
 					for _, cl := range cls {
+						used[cl] = true
 						rvii := reflect.New(rvi.Type().Elem())
-						if err := fromContentLine(rvii, cl); err != nil {
+						if err := fromContentLine(rvii, cl, types); err != nil {
+							return err
+						}
+						if err := fromGroupedContentLines(rvii, cl, o, used, types); err != nil {
 							return err
 						}
 						rvi.Set(reflect.Append(rvi, reflect.Indirect(rvii)))
 					}
-				} else {
-					if err := fromContentLine(rvi.Addr(), cls[0]); err != nil {
+				case opts.multiple:
+					// the values of all lines are appended to the list
+					for _, cl := range cls {
+						used[cl] = true
+						if err := fromContentLine(rvi.Addr(), cl, types); err != nil {
+							return err
+						}
+					}
+				default:
+					used[cls[0]] = true
+					if err := fromContentLine(rvi.Addr(), cls[0], types); err != nil {
+						return err
+					}
+					if err := fromGroupedContentLines(rvi.Addr(), cls[0], o, used, types); err != nil {
 						return err
 					}
 				}
 			}
 		}
+		for _, rvi := range extra {
+			if err := extraFromObject(rvi, o, used, usedObjects); err != nil {
+				return err
+			}
+		}
 	default:
 		return errors.New("Cannot unmarshal object into " + rv.Type().String())
 	}
 	return nil
 }
 
-func fromContentLine(rv reflect.Value, cl *ContentLine) error {
+// fromGroupedContentLines fills the ",grouped" fields of the struct rv points
+// to from the properties of o in the group of cl, marking them as used.
+func fromGroupedContentLines(rv reflect.Value, cl *ContentLine, o *Object, used map[*ContentLine]bool, types *sync.Map) error {
+	if cl.Group == "" {
+		return nil
+	}
+	for rv.Kind() == reflect.Ptr {
+		if rv.IsNil() {
+			return nil
+		}
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct || rv.Type() == timeType || implements(reflect.PtrTo(rv.Type()), unmarshalerType) {
+		return nil
+	}
+	ti, err := cachedTypeInfo(types, rv.Type())
+	if err != nil {
+		return err
+	}
+	for _, f := range ti.fields {
+		if f.opts.kind != "grouped" {
+			continue
+		}
+		for _, gcl := range o.Properties {
+			if gcl == cl || !strings.EqualFold(gcl.Group, cl.Group) || !strings.EqualFold(gcl.Name, f.name) {
+				continue
+			}
+			used[gcl] = true
+			rvi, _ := fieldByIndex(rv, f.index, true)
+			if err := fromContentLine(rvi.Addr(), gcl, types); err != nil {
+				return err
+			}
+			break
+		}
+	}
+	return nil
+}
+
+// extraFromObject stores the properties and objects of o that no other field
+// took in a field tagged ",extra".
+func extraFromObject(rv reflect.Value, o *Object, used map[*ContentLine]bool, usedObjects map[*Object]bool) error {
+	switch rv.Type() {
+	case contentLinesType:
+		var cls []*ContentLine
+		for _, cl := range o.Properties {
+			if !used[cl] && !isStructural(cl) {
+				cls = append(cls, cl)
+			}
+		}
+		rv.Set(reflect.ValueOf(cls))
+	case contentLineMap:
+		m := make(map[string][]*ContentLine)
+		for _, cl := range o.Properties {
+			if !used[cl] && !isStructural(cl) {
+				name := strings.ToUpper(cl.Name)
+				m[name] = append(m[name], cl)
+			}
+		}
+		if len(m) > 0 {
+			rv.Set(reflect.ValueOf(m))
+		}
+	case objectsType:
+		var objects []*Object
+		for _, so := range o.Objects {
+			if !usedObjects[so] {
+				objects = append(objects, so)
+			}
+		}
+		rv.Set(reflect.ValueOf(objects))
+	default:
+		return errors.New("Cannot unmarshal extra properties into " + rv.Type().String())
+	}
+	return nil
+}
+
+// isStructural reports whether cl delimits an object rather than being one
+// of its properties.
+func isStructural(cl *ContentLine) bool {
+	name := strings.ToUpper(cl.Name)
+	return name == "BEGIN" || name == "END"
+}
+
+func fromContentLine(rv reflect.Value, cl *ContentLine, types *sync.Map) error {
 	if rv.Kind() != reflect.Ptr {
 		return errors.New("Cannot unmarshal property into non-pointer " + rv.Type().String())
 	}
 	if rv.IsNil() {
 		rv.Set(reflect.New(rv.Type().Elem()))
 	}
+	// pointer fields to structs and Unmarshalers are allocated on demand
+	if rv.Elem().Kind() == reflect.Ptr && !isScalar(rv.Elem().Type()) {
+		if rv.Elem().IsNil() {
+			rv.Elem().Set(reflect.New(rv.Elem().Type().Elem()))
+		}
+		rv = rv.Elem()
+	}
+	if u, ok := rv.Interface().(Unmarshaler); ok {
+		return u.UnmarshalVdir(cl)
+	}
 
-	switch rv.Elem().Kind() {
-	case reflect.Struct:
+	// unknown zones, for example Windows names, are read as floating
+	_, tzid := paramValue(cl.Params, "TZID")
+	loc := tzLocation(tzid)
+
+	switch {
+	case rv.Elem().Kind() == reflect.Struct && rv.Elem().Type() != timeType:
 		typ := rv.Type()
 		vi := 0
-		for i := 0; i < typ.Elem().NumField(); i++ {
-			name, opt := fieldToProp(typ.Elem().Field(i))
-			if name == "-" {
+		params := make(map[string]bool)
+		var extraParams []reflect.Value
+		ti, err := cachedTypeInfo(types, typ.Elem())
+		if err != nil {
+			return err
+		}
+		for _, f := range ti.fields {
+			name, opts := f.name, f.opts
+			rvi, _ := fieldByIndex(rv.Elem(), f.index, true)
+			if opts.kind == "extra" {
+				extraParams = append(extraParams, rvi)
+				continue
+			}
+			if opts.kind == "group" {
+				if rvi.Kind() != reflect.String {
+					return errors.New("Cannot unmarshal group into " + rvi.Type().String())
+				}
+				rvi.SetString(cl.Group)
+				continue
+			}
+			if opts.kind == "grouped" {
 				continue
 			}
-			if opt == "param" {
+			if opts.kind == "param" {
+				params[name] = true
 				if v, ok := cl.Params[name]; ok {
-					if err := fromValue(rv.Elem().Field(i).Addr(), v); err != nil {
+					if err := fromValue(rvi.Addr(), v, loc); err != nil {
 						return err
 					}
+				} else if opts.required {
+					return errors.New("Missing required parameter " + name + " of " + cl.Name)
 				}
 			} else {
+				if opts.required && (len(cl.Value) <= vi || isEmptyValue(cl.Value[vi:vi+1])) {
+					return errors.New("Missing required " + f.field + " value of " + cl.Name)
+				}
 				if len(cl.Value) > vi {
-					if err := fromValue(rv.Elem().Field(i).Addr(), cl.Value[vi]); err != nil {
+					if err := fromValue(rvi.Addr(), cl.Value[vi], loc); err != nil {
 						return err
 					}
 					vi++
 				}
 			}
 		}
+		for _, rvi := range extraParams {
+			if rvi.Type() != paramsType {
+				return errors.New("Cannot unmarshal extra parameters into " + rvi.Type().String())
+			}
+			for k, v := range cl.Params {
+				if params[k] {
+					continue
+				}
+				if rvi.IsNil() {
+					rvi.Set(reflect.MakeMap(paramsType))
+				}
+				rvi.SetMapIndex(reflect.ValueOf(k), reflect.ValueOf(v))
+			}
+		}
 		return nil
 	default:
 		if len(cl.Value) > 0 {
-			if err := fromValue(rv, cl.Value[0]); err != nil {
+			if err := fromValue(rv, cl.Value[0], loc); err != nil {
 				return err
 			}
 		}
@@ -275,7 +1115,9 @@ func fromContentLine(rv reflect.Value, cl *ContentLine) error {
 	return nil
 }
 
-func fromValue(rv reflect.Value, v Value) error {
+// fromValue stores v in the scalar or slice of scalars rv points to. Local
+// times are read in loc.
+func fromValue(rv reflect.Value, v Value, loc *time.Location) error {
 	if rv.Kind() != reflect.Ptr {
 		return errors.New("Cannot unmarshal value into non-pointer " + rv.Type().String())
 	}
@@ -283,18 +1125,75 @@ func fromValue(rv reflect.Value, v Value) error {
 		rv.Set(reflect.New(rv.Type().Elem()))
 	}
 
-	switch rv.Elem().Kind() {
-	case reflect.String:
-		rv.Elem().SetString(v.GetText())
-		return nil
-	case reflect.Slice:
-		if rv.Type().Elem().Elem().Kind() != reflect.String {
-			break
-		}
+	elem := rv.Elem()
+	if elem.Kind() == reflect.Slice && isScalar(elem.Type().Elem()) {
 		for _, vv := range v {
-			rv.Elem().Set(reflect.Append(rv.Elem(), reflect.ValueOf(vv)))
+			rvi := reflect.New(elem.Type().Elem())
+			if err := parseScalar(rvi.Elem(), vv, loc); err != nil {
+				return err
+			}
+			elem.Set(reflect.Append(elem, rvi.Elem()))
 		}
 		return nil
 	}
-	return errors.New("Cannot unmarshal value into " + rv.Type().String())
+	if !isScalar(elem.Type()) {
+		return errors.New("Cannot unmarshal value into " + rv.Type().String())
+	}
+	return parseScalar(elem, v.GetText(), loc)
+}
+
+// parseScalar stores s in the scalar rv. An empty s leaves rv unchanged.
+func parseScalar(rv reflect.Value, s string, loc *time.Location) error {
+	if s == "" {
+		return nil
+	}
+	if rv.Kind() == reflect.Ptr {
+		rv.Set(reflect.New(rv.Type().Elem()))
+		rv = rv.Elem()
+	}
+	if rv.Kind() == reflect.String {
+		rv.SetString(s)
+		return nil
+	}
+
+	var err error
+	switch {
+	case rv.Type() == timeType:
+		var t time.Time
+		if t, _, err = parseDateTime(s, loc); err == nil {
+			rv.Set(reflect.ValueOf(t))
+		}
+	case rv.Type() == durationType:
+		var d time.Duration
+		if d, err = parseDuration(s); err == nil {
+			rv.SetInt(int64(d))
+		}
+	default:
+		switch rv.Kind() {
+		case reflect.Bool:
+			var b bool
+			if b, err = strconv.ParseBool(s); err == nil {
+				rv.SetBool(b)
+			}
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			var i int64
+			if i, err = strconv.ParseInt(strings.TrimPrefix(s, "+"), 10, rv.Type().Bits()); err == nil {
+				rv.SetInt(i)
+			}
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			var u uint64
+			if u, err = strconv.ParseUint(s, 10, rv.Type().Bits()); err == nil {
+				rv.SetUint(u)
+			}
+		case reflect.Float32, reflect.Float64:
+			var f float64
+			if f, err = strconv.ParseFloat(s, rv.Type().Bits()); err == nil {
+				rv.SetFloat(f)
+			}
+		}
+	}
+	if err != nil {
+		return errors.New("Cannot unmarshal " + strconv.Quote(s) + " into " + rv.Type().String() + ": " + err.Error())
+	}
+	return nil
 }
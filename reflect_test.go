@@ -0,0 +1,341 @@
+package golib_vcard
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestUnnamedObjectProfile(t *testing.T) {
+	const in = "BEGIN:VCALENDAR\r\n" +
+		"VERSION:2.0\r\n" +
+		"BEGIN:VEVENT\r\n" +
+		"UID:1\r\n" +
+		"BEGIN:VALARM\r\n" +
+		"ACTION:DISPLAY\r\n" +
+		"TRIGGER:-PT15M\r\n" +
+		"END:VALARM\r\n" +
+		"END:VEVENT\r\n" +
+		"END:VCALENDAR\r\n"
+	var c Calendar
+	if err := Unmarshal([]byte(in), &c); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Events) != 1 || len(c.Events[0].Alarms) != 1 || c.Events[0].Alarms[0].Trigger != "-PT15M" {
+		t.Fatalf("got events %+v", c.Events)
+	}
+	out, err := Marshal(&c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(out), "BEGIN:VALARM\r\nTRIGGER:-PT15M\r\nACTION:DISPLAY\r\n") {
+		t.Errorf("got %q, want a VALARM object", out)
+	}
+}
+
+type scalarParam struct {
+	Pref  int  `vdir:",param"`
+	Level *int `vdir:",param"`
+	Value string
+}
+
+type scalars struct {
+	Profile  string `vdir:"vcard,profile"`
+	String   string
+	Int      int
+	Int8     int8
+	Int64    int64
+	Uint     uint
+	Uint16   uint16
+	Float32  float32
+	Float64  float64
+	Bool     bool
+	Time     time.Time
+	Duration time.Duration
+	IntPtr   *int
+	TimePtr  *time.Time
+	Ints     []int `vdir:"x-ints"`
+	Floats   []float64
+	Param    scalarParam
+}
+
+func TestUnmarshalEmptyScalars(t *testing.T) {
+	names := []string{"STRING", "INT", "INT8", "INT64", "UINT", "UINT16", "FLOAT32", "FLOAT64",
+		"BOOL", "TIME", "DURATION", "INTPTR", "TIMEPTR", "X-INTS", "FLOATS"}
+	for _, name := range names {
+		in := "BEGIN:VCARD\r\n" + name + ":\r\nEND:VCARD\r\n"
+		var v scalars
+		if err := Unmarshal([]byte(in), &v); err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if !reflect.DeepEqual(v, scalars{Profile: "VCARD"}) {
+			t.Errorf("%s: got %+v, want zero values", name, v)
+		}
+	}
+
+	var v scalars
+	if err := Unmarshal([]byte("BEGIN:VCARD\r\nPARAM;PREF=;LEVEL=:x\r\nEND:VCARD\r\n"), &v); err != nil {
+		t.Fatal(err)
+	}
+	if v.Param.Pref != 0 || v.Param.Level != nil || v.Param.Value != "x" {
+		t.Errorf("got %+v for empty parameters", v.Param)
+	}
+}
+
+func TestUnmarshalScalars(t *testing.T) {
+	tests := []struct {
+		line  string
+		check func(v scalars) bool
+	}{
+		{"STRING:abc", func(v scalars) bool { return v.String == "abc" }},
+		{"INT:-42", func(v scalars) bool { return v.Int == -42 }},
+		{"INT:+7", func(v scalars) bool { return v.Int == 7 }},
+		{"INT8:127", func(v scalars) bool { return v.Int8 == 127 }},
+		{"INT64:9000000000", func(v scalars) bool { return v.Int64 == 9000000000 }},
+		{"UINT:3", func(v scalars) bool { return v.Uint == 3 }},
+		{"UINT16:65535", func(v scalars) bool { return v.Uint16 == 65535 }},
+		{"FLOAT32:1.5", func(v scalars) bool { return v.Float32 == 1.5 }},
+		{"FLOAT64:-122.082932", func(v scalars) bool { return v.Float64 == -122.082932 }},
+		{"BOOL:TRUE", func(v scalars) bool { return v.Bool }},
+		{"TIME:19970714T173000Z", func(v scalars) bool {
+			return v.Time.Equal(time.Date(1997, 7, 14, 17, 30, 0, 0, time.UTC))
+		}},
+		{"TIME;VALUE=DATE:19970714", func(v scalars) bool { return v.Time.Year() == 1997 && v.Time.Day() == 14 }},
+		{"TIME:1997-07-14T17:30:00+02:00", func(v scalars) bool {
+			return v.Time.Equal(time.Date(1997, 7, 14, 15, 30, 0, 0, time.UTC))
+		}},
+		{"DURATION:PT1H30M", func(v scalars) bool { return v.Duration == 90*time.Minute }},
+		{"DURATION:-P1W", func(v scalars) bool { return v.Duration == -7*24*time.Hour }},
+		{"INTPTR:0", func(v scalars) bool { return v.IntPtr != nil && *v.IntPtr == 0 }},
+		{"TIMEPTR:20240101T000000Z", func(v scalars) bool { return v.TimePtr != nil && v.TimePtr.Year() == 2024 }},
+		{"X-INTS:1,,2", func(v scalars) bool { return reflect.DeepEqual(v.Ints, []int{1, 0, 2}) }},
+		{"FLOATS:1.5,2", func(v scalars) bool { return reflect.DeepEqual(v.Floats, []float64{1.5, 2}) }},
+		{"PARAM;PREF=2;LEVEL=3:x", func(v scalars) bool {
+			return v.Param.Pref == 2 && v.Param.Level != nil && *v.Param.Level == 3
+		}},
+	}
+	for _, test := range tests {
+		var v scalars
+		if err := Unmarshal([]byte("BEGIN:VCARD\r\n"+test.line+"\r\nEND:VCARD\r\n"), &v); err != nil {
+			t.Errorf("%s: %v", test.line, err)
+			continue
+		}
+		if !test.check(v) {
+			t.Errorf("%s: got %+v", test.line, v)
+		}
+	}
+}
+
+func TestUnmarshalScalarErrors(t *testing.T) {
+	for _, line := range []string{
+		"INT:abc", "INT8:128", "UINT:-1", "FLOAT64:1.5x", "BOOL:maybe",
+		"TIME:yesterday", "DURATION:P1Y", "X-INTS:1,x", "PARAM;PREF=first:x",
+	} {
+		var v scalars
+		if err := Unmarshal([]byte("BEGIN:VCARD\r\n"+line+"\r\nEND:VCARD\r\n"), &v); err == nil {
+			t.Errorf("%s: got no error", line)
+		}
+	}
+}
+
+func TestMarshalScalars(t *testing.T) {
+	n := 0
+	v := scalars{
+		Int:      -42,
+		Float64:  1.5,
+		Bool:     true,
+		Time:     time.Date(1997, 7, 14, 17, 30, 0, 0, time.UTC),
+		Duration: 90 * time.Minute,
+		IntPtr:   &n,
+		Ints:     []int{1, 2},
+		Param:    scalarParam{Pref: 1, Value: "x"},
+	}
+	out, err := Marshal(&v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		"INT:-42\r\n", "FLOAT64:1.5\r\n", "BOOL:TRUE\r\n", "TIME:19970714T173000Z\r\n",
+		"DURATION:PT1H30M\r\n", "INTPTR:0\r\n", "X-INTS:1,2\r\n", "PARAM;PREF=1:x\r\n",
+	} {
+		if !strings.Contains(string(out), line) {
+			t.Errorf("got %q, want line %q", out, line)
+		}
+	}
+	if strings.Contains(string(out), "UINT:") {
+		t.Errorf("got %q, want zero values omitted", out)
+	}
+}
+
+const benchCalendar = "BEGIN:VCALENDAR\r\n" +
+	"VERSION:2.0\r\n" +
+	"PRODID:-//Example//Bench//EN\r\n" +
+	"BEGIN:VTIMEZONE\r\n" +
+	"TZID:America/New_York\r\n" +
+	"BEGIN:STANDARD\r\n" +
+	"DTSTART:19671029T020000\r\n" +
+	"RRULE:FREQ=YEARLY;BYDAY=1SU;BYMONTH=11\r\n" +
+	"TZOFFSETFROM:-0400\r\n" +
+	"TZOFFSETTO:-0500\r\n" +
+	"TZNAME:EST\r\n" +
+	"END:STANDARD\r\n" +
+	"END:VTIMEZONE\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:19970901T130000Z-123401@example.com\r\n" +
+	"DTSTAMP:19970901T130000Z\r\n" +
+	"DTSTART;TZID=America/New_York:19970903T163000\r\n" +
+	"DTEND;TZID=America/New_York:19970903T190000\r\n" +
+	"SUMMARY:Annual Employee Review\r\n" +
+	"CLASS:PRIVATE\r\n" +
+	"CATEGORIES:BUSINESS,HUMAN RESOURCES\r\n" +
+	"RRULE:FREQ=WEEKLY;COUNT=10;BYDAY=TU,TH\r\n" +
+	"EXDATE;TZID=America/New_York:19970909T163000\r\n" +
+	"BEGIN:VALARM\r\n" +
+	"ACTION:DISPLAY\r\n" +
+	"TRIGGER:-PT15M\r\n" +
+	"DESCRIPTION:Reminder\r\n" +
+	"END:VALARM\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VTODO\r\n" +
+	"UID:20070313T123432Z-456553@example.com\r\n" +
+	"DTSTAMP:20070313T123432Z\r\n" +
+	"DUE;VALUE=DATE:20070501\r\n" +
+	"SUMMARY:Submit Quebec Income Tax Return for 2006\r\n" +
+	"STATUS:NEEDS-ACTION\r\n" +
+	"END:VTODO\r\n" +
+	"END:VCALENDAR\r\n"
+
+func BenchmarkUnmarshalCard(b *testing.B) {
+	data := benchCards(1)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var c Card
+		if err := Unmarshal(data, &c); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkMarshalCard(b *testing.B) {
+	var c Card
+	if err := Unmarshal(benchCards(1), &c); err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := Marshal(&c); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkUnmarshalCalendar(b *testing.B) {
+	data := []byte(benchCalendar)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var c Calendar
+		if err := Unmarshal(data, &c); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkMarshalCalendar(b *testing.B) {
+	var c Calendar
+	if err := Unmarshal([]byte(benchCalendar), &c); err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := Marshal(&c); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkFromObjectUncached measures FromObject with the field metadata
+// parsed anew for every Card, as it was before typeInfos cached it. Each Card
+// gets a cache of its own, so the global typeInfos is left alone.
+func BenchmarkFromObjectUncached(b *testing.B) {
+	o, err := NewDecoder(bytes.NewReader(benchCards(1))).ReadObject()
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var c Card
+		if err := fromObject(&c, o, new(sync.Map)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkFromObject(b *testing.B) {
+	o, err := NewDecoder(bytes.NewReader(benchCards(1))).ReadObject()
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var c Card
+		if err := FromObject(&c, o); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+type EmbeddedA struct {
+	Note  string
+	Title string `vdir:"title"`
+	Role  string
+}
+
+type EmbeddedB struct {
+	Note  string
+	Title string
+	Role  string `vdir:"role"`
+	Kind  string
+}
+
+type embeddingCard struct {
+	Profile string `vdir:"vcard,profile"`
+	EmbeddedA
+	*EmbeddedB
+	Kind string `vdir:"x-kind"`
+	Uid  string
+}
+
+func TestEmbeddedFieldConflicts(t *testing.T) {
+	v := embeddingCard{
+		EmbeddedA: EmbeddedA{Note: "a", Title: "title a", Role: "role a"},
+		EmbeddedB: &EmbeddedB{Note: "b", Title: "title b", Role: "role b", Kind: "kind b"},
+		Kind:      "outer",
+		Uid:       "1",
+	}
+	out, err := Marshal(&v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "BEGIN:VCARD\r\nTITLE:title a\r\nROLE:role b\r\nKIND:kind b\r\nX-KIND:outer\r\nUID:1\r\nEND:VCARD\r\n"
+	if string(out) != want {
+		t.Errorf("got %q, want %q", out, want)
+	}
+
+	var got embeddingCard
+	in := "BEGIN:VCARD\r\nNOTE:n\r\nTITLE:t\r\nROLE:r\r\nKIND:k\r\nEND:VCARD\r\n"
+	if err := Unmarshal([]byte(in), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.EmbeddedA.Note != "" || got.EmbeddedA.Title != "t" || got.EmbeddedA.Role != "" {
+		t.Errorf("got %+v", got.EmbeddedA)
+	}
+	if got.EmbeddedB == nil || got.EmbeddedB.Note != "" || got.EmbeddedB.Title != "" || got.EmbeddedB.Role != "r" || got.EmbeddedB.Kind != "k" {
+		t.Errorf("got %+v", got.EmbeddedB)
+	}
+}
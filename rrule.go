@@ -0,0 +1,453 @@
+package golib_vcard
+
+import (
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Frequency is the FREQ of a recurrence rule.
+type Frequency string
+
+const (
+	Secondly Frequency = "SECONDLY"
+	Minutely Frequency = "MINUTELY"
+	Hourly   Frequency = "HOURLY"
+	Daily    Frequency = "DAILY"
+	Weekly   Frequency = "WEEKLY"
+	Monthly  Frequency = "MONTHLY"
+	Yearly   Frequency = "YEARLY"
+)
+
+// Weekday is a day of the week in a recurrence rule. The zero value is no
+// day, so that an unset WKST can be told from Sunday.
+type Weekday int
+
+const (
+	Monday Weekday = iota + 1
+	Tuesday
+	Wednesday
+	Thursday
+	Friday
+	Saturday
+	Sunday
+)
+
+var weekdayNames = [...]string{"", "MO", "TU", "WE", "TH", "FR", "SA", "SU"}
+
+// String returns the two-letter iCalendar name of d, such as "MO".
+func (d Weekday) String() string {
+	if d < Monday || d > Sunday {
+		return ""
+	}
+	return weekdayNames[d]
+}
+
+// Weekday returns d as a time.Weekday.
+func (d Weekday) Weekday() time.Weekday {
+	return time.Weekday(d % 7)
+}
+
+func parseWeekday(s string) (Weekday, bool) {
+	for d := Monday; d <= Sunday; d++ {
+		if strings.EqualFold(s, weekdayNames[d]) {
+			return d, true
+		}
+	}
+	return 0, false
+}
+
+// WeekdayNum is a BYDAY value: a weekday with an optional ordinal, so that
+// -1SU is the last Sunday of the month or year. Ordinal 0 is every such day.
+type WeekdayNum struct {
+	Ordinal int
+	Day     Weekday
+}
+
+// String returns the BYDAY form of w, such as "MO" or "-1SU".
+func (w WeekdayNum) String() string {
+	if w.Ordinal == 0 {
+		return w.Day.String()
+	}
+	return strconv.Itoa(w.Ordinal) + w.Day.String()
+}
+
+// Recurrence is an RFC 5545 recurrence rule as found in RRULE and EXRULE.
+// Zero fields are not part of the rule; an Interval of 0 means 1. A
+// Recurrence without Freq is no rule at all.
+type Recurrence struct {
+	Freq       Frequency
+	Until      time.Time
+	UntilDate  bool // UNTIL is a DATE rather than a DATE-TIME
+	Count      int
+	Interval   int
+	BySecond   []int
+	ByMinute   []int
+	ByHour     []int
+	ByDay      []WeekdayNum
+	ByMonthDay []int
+	ByYearDay  []int
+	ByWeekNo   []int
+	ByMonth    []int
+	BySetPos   []int
+	WeekStart  Weekday
+	Extra      []string `json:",omitempty"` // unknown parts such as "X-NAME=value"
+
+	// order lists the names of the parts in the order they were parsed, so
+	// that String reproduces the rule, including INTERVAL=1 and WKST=MO
+	order []string
+}
+
+// rruleParts lists the parts of a rule in the order String writes them.
+var rruleParts = []string{"FREQ", "UNTIL", "COUNT", "INTERVAL", "BYSECOND", "BYMINUTE",
+	"BYHOUR", "BYDAY", "BYMONTHDAY", "BYYEARDAY", "BYWEEKNO", "BYMONTH", "BYSETPOS", "WKST"}
+
+// ParseRecurrence parses the text of a recurrence rule such as
+// "FREQ=WEEKLY;BYDAY=MO,WE;UNTIL=20061224T000000Z". Part names are case
+// insensitive and unknown parts are kept in Extra. It checks the syntax of
+// the parts only; see Validate for their combination.
+func ParseRecurrence(s string) (*Recurrence, error) {
+	r := &Recurrence{}
+	seen := make(map[string]bool)
+	for _, part := range strings.Split(s, ";") {
+		if part == "" {
+			continue
+		}
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, errors.New("Malformed RRULE part " + strconv.Quote(part))
+		}
+		name, value := strings.ToUpper(kv[0]), kv[1]
+		if seen[name] {
+			return nil, errors.New("Duplicate RRULE part " + name)
+		}
+		seen[name] = true
+		var err error
+		switch name {
+		case "FREQ":
+			r.Freq = Frequency(strings.ToUpper(value))
+			switch r.Freq {
+			case Secondly, Minutely, Hourly, Daily, Weekly, Monthly, Yearly:
+			default:
+				err = errors.New("Unknown RRULE frequency " + strconv.Quote(value))
+			}
+		case "UNTIL":
+			r.Until, r.UntilDate, err = parseDateTime(value, nil)
+		case "COUNT":
+			r.Count, err = strconv.Atoi(value)
+		case "INTERVAL":
+			r.Interval, err = strconv.Atoi(value)
+		case "BYSECOND":
+			r.BySecond, err = parseInts(value)
+		case "BYMINUTE":
+			r.ByMinute, err = parseInts(value)
+		case "BYHOUR":
+			r.ByHour, err = parseInts(value)
+		case "BYDAY":
+			r.ByDay, err = parseWeekdayNums(value)
+		case "BYMONTHDAY":
+			r.ByMonthDay, err = parseInts(value)
+		case "BYYEARDAY":
+			r.ByYearDay, err = parseInts(value)
+		case "BYWEEKNO":
+			r.ByWeekNo, err = parseInts(value)
+		case "BYMONTH":
+			r.ByMonth, err = parseInts(value)
+		case "BYSETPOS":
+			r.BySetPos, err = parseInts(value)
+		case "WKST":
+			var ok bool
+			if r.WeekStart, ok = parseWeekday(value); !ok {
+				err = errors.New("Unknown RRULE weekday " + strconv.Quote(value))
+			}
+		default:
+			r.Extra = append(r.Extra, part)
+		}
+		if err != nil {
+			return nil, errors.New("Malformed RRULE part " + strconv.Quote(part) + ": " + err.Error())
+		}
+		r.order = append(r.order, name)
+	}
+	if r.Freq == "" {
+		return nil, errors.New("Missing RRULE part FREQ")
+	}
+	return r, nil
+}
+
+func parseInts(s string) ([]int, error) {
+	var ns []int
+	for _, v := range strings.Split(s, ",") {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, err
+		}
+		ns = append(ns, n)
+	}
+	return ns, nil
+}
+
+func parseWeekdayNums(s string) ([]WeekdayNum, error) {
+	var days []WeekdayNum
+	for _, v := range strings.Split(s, ",") {
+		if len(v) < 2 {
+			return nil, errors.New("Malformed weekday " + strconv.Quote(v))
+		}
+		day, ok := parseWeekday(v[len(v)-2:])
+		if !ok {
+			return nil, errors.New("Malformed weekday " + strconv.Quote(v))
+		}
+		w := WeekdayNum{Day: day}
+		if n := v[:len(v)-2]; n != "" {
+			var err error
+			if w.Ordinal, err = strconv.Atoi(n); err != nil || w.Ordinal == 0 {
+				return nil, errors.New("Malformed weekday " + strconv.Quote(v))
+			}
+		}
+		days = append(days, w)
+	}
+	return days, nil
+}
+
+// part returns the value of the part name, or "" if r does not have it.
+// INTERVAL=1 and WKST are only written if they were parsed or differ from
+// the default.
+func (r *Recurrence) part(name string, parsed bool) string {
+	switch name {
+	case "FREQ":
+		return string(r.Freq)
+	case "UNTIL":
+		if r.Until.IsZero() {
+			return ""
+		}
+		t := r.Until
+		if !r.UntilDate && t.Location() != time.Local {
+			// a rule of a DTSTART with a time zone ends in UTC
+			t = t.UTC()
+		}
+		return formatDateTime(t, r.UntilDate)
+	case "COUNT":
+		if r.Count != 0 {
+			return strconv.Itoa(r.Count)
+		}
+	case "INTERVAL":
+		if r.Interval > 1 || r.Interval != 0 && parsed {
+			return strconv.Itoa(r.Interval)
+		}
+	case "BYSECOND":
+		return formatInts(r.BySecond)
+	case "BYMINUTE":
+		return formatInts(r.ByMinute)
+	case "BYHOUR":
+		return formatInts(r.ByHour)
+	case "BYDAY":
+		days := make([]string, len(r.ByDay))
+		for i, d := range r.ByDay {
+			days[i] = d.String()
+		}
+		return strings.Join(days, ",")
+	case "BYMONTHDAY":
+		return formatInts(r.ByMonthDay)
+	case "BYYEARDAY":
+		return formatInts(r.ByYearDay)
+	case "BYWEEKNO":
+		return formatInts(r.ByWeekNo)
+	case "BYMONTH":
+		return formatInts(r.ByMonth)
+	case "BYSETPOS":
+		return formatInts(r.BySetPos)
+	case "WKST":
+		if r.WeekStart != Monday || parsed {
+			return r.WeekStart.String()
+		}
+	}
+	return ""
+}
+
+func formatInts(ns []int) string {
+	s := make([]string, len(ns))
+	for i, n := range ns {
+		s[i] = strconv.Itoa(n)
+	}
+	return strings.Join(s, ",")
+}
+
+// parts returns the "NAME=value" parts of r, those that were parsed in their
+// original order followed by the others.
+func (r *Recurrence) parts() []string {
+	var parts []string
+	done := make(map[string]bool)
+	extra := 0
+	for _, name := range r.order {
+		if done[name] {
+			continue
+		}
+		done[name] = true
+		if v := r.part(name, true); v != "" {
+			parts = append(parts, name+"="+v)
+		} else if !isRRulePart(name) && extra < len(r.Extra) {
+			parts = append(parts, r.Extra[extra])
+			extra++
+		}
+	}
+	for _, name := range rruleParts {
+		if !done[name] {
+			if v := r.part(name, false); v != "" {
+				parts = append(parts, name+"="+v)
+			}
+		}
+	}
+	return append(parts, r.Extra[extra:]...)
+}
+
+func isRRulePart(name string) bool {
+	for _, p := range rruleParts {
+		if p == name {
+			return true
+		}
+	}
+	return false
+}
+
+// String returns the text of the rule as used in RRULE.
+func (r *Recurrence) String() string {
+	return strings.Join(r.parts(), ";")
+}
+
+// Validate checks the rule against the constraints of RFC 5545: FREQ is
+// set, UNTIL and COUNT do not both occur, values are in range and the BYxxx
+// parts are allowed with the frequency.
+func (r *Recurrence) Validate() error {
+	switch r.Freq {
+	case Secondly, Minutely, Hourly, Daily, Weekly, Monthly, Yearly:
+	case "":
+		return errors.New("Missing RRULE part FREQ")
+	default:
+		return errors.New("Unknown RRULE frequency " + strconv.Quote(string(r.Freq)))
+	}
+	if !r.Until.IsZero() && r.Count != 0 {
+		return errors.New("RRULE must not have both UNTIL and COUNT")
+	}
+	if r.Count < 0 {
+		return errors.New("RRULE COUNT must be positive")
+	}
+	if r.Interval < 0 {
+		return errors.New("RRULE INTERVAL must be positive")
+	}
+	for _, c := range []struct {
+		name     string
+		values   []int
+		min, max int
+		signed   bool
+	}{
+		{"BYSECOND", r.BySecond, 0, 60, false},
+		{"BYMINUTE", r.ByMinute, 0, 59, false},
+		{"BYHOUR", r.ByHour, 0, 23, false},
+		{"BYMONTHDAY", r.ByMonthDay, 1, 31, true},
+		{"BYYEARDAY", r.ByYearDay, 1, 366, true},
+		{"BYWEEKNO", r.ByWeekNo, 1, 53, true},
+		{"BYMONTH", r.ByMonth, 1, 12, false},
+		{"BYSETPOS", r.BySetPos, 1, 366, true},
+	} {
+		for _, v := range c.values {
+			if c.signed && v < 0 {
+				v = -v
+			}
+			if v < c.min || v > c.max {
+				return errors.New("RRULE " + c.name + " value " + strconv.Itoa(v) + " out of range")
+			}
+		}
+	}
+	for _, d := range r.ByDay {
+		if d.Day < Monday || d.Day > Sunday {
+			return errors.New("RRULE BYDAY has an invalid weekday")
+		}
+		if d.Ordinal == 0 {
+			continue
+		}
+		if d.Ordinal < -53 || d.Ordinal > 53 {
+			return errors.New("RRULE BYDAY ordinal " + strconv.Itoa(d.Ordinal) + " out of range")
+		}
+		if r.Freq != Monthly && r.Freq != Yearly {
+			return errors.New("RRULE BYDAY ordinals are only allowed with FREQ=MONTHLY or YEARLY")
+		}
+		if r.Freq == Yearly && len(r.ByWeekNo) > 0 {
+			return errors.New("RRULE BYDAY ordinals are not allowed with BYWEEKNO")
+		}
+	}
+	if r.WeekStart != 0 && (r.WeekStart < Monday || r.WeekStart > Sunday) {
+		return errors.New("RRULE WKST has an invalid weekday")
+	}
+	if len(r.ByWeekNo) > 0 && r.Freq != Yearly {
+		return errors.New("RRULE BYWEEKNO is only allowed with FREQ=YEARLY")
+	}
+	if len(r.ByYearDay) > 0 && (r.Freq == Daily || r.Freq == Weekly || r.Freq == Monthly) {
+		return errors.New("RRULE BYYEARDAY is not allowed with FREQ=" + string(r.Freq))
+	}
+	if len(r.ByMonthDay) > 0 && r.Freq == Weekly {
+		return errors.New("RRULE BYMONTHDAY is not allowed with FREQ=WEEKLY")
+	}
+	if len(r.BySetPos) > 0 && len(r.BySecond)+len(r.ByMinute)+len(r.ByHour)+len(r.ByDay)+
+		len(r.ByMonthDay)+len(r.ByYearDay)+len(r.ByWeekNo)+len(r.ByMonth) == 0 {
+		return errors.New("RRULE BYSETPOS requires another BYxxx part")
+	}
+	return nil
+}
+
+// MarshalVdir implements Marshaler, writing the rule as a structured value.
+func (r *Recurrence) MarshalVdir() (*ContentLine, error) {
+	if r.Freq == "" {
+		return nil, nil
+	}
+	cl := &ContentLine{}
+	for _, part := range r.parts() {
+		cl.Value = append(cl.Value, strings.Split(part, ","))
+	}
+	return cl, nil
+}
+
+// UnmarshalVdir implements Unmarshaler.
+func (r *Recurrence) UnmarshalVdir(cl *ContentLine) error {
+	parts := make([]string, len(cl.Value))
+	for i, v := range cl.Value {
+		parts[i] = strings.Join(v, ",")
+	}
+	rr, err := ParseRecurrence(strings.Join(parts, ";"))
+	if err != nil {
+		return err
+	}
+	*r = *rr
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler, writing the rule as its text.
+func (r *Recurrence) MarshalJSON() ([]byte, error) {
+	return json.Marshal(r.String())
+}
+
+// UnmarshalJSON implements json.Unmarshaler. It accepts the text of the rule
+// and the RecurrenceRule object of earlier versions; an empty text or object
+// is no rule.
+func (r *Recurrence) UnmarshalJSON(data []byte) error {
+	var s string
+	if len(data) > 0 && data[0] == '{' {
+		var rule RecurrenceRule
+		if err := json.Unmarshal(data, &rule); err != nil {
+			return err
+		}
+		s = strings.Join([]string{rule.Rule1, rule.Rule2, rule.Rule3, rule.Rule4, rule.Rule5}, ";")
+	} else if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	if strings.Trim(s, ";") == "" {
+		*r = Recurrence{}
+		return nil
+	}
+	rr, err := ParseRecurrence(s)
+	if err != nil {
+		return err
+	}
+	*r = *rr
+	return nil
+}
@@ -0,0 +1,108 @@
+package golib_vcard
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRecurrenceString(t *testing.T) {
+	tests := []string{
+		"FREQ=DAILY;COUNT=10",
+		"FREQ=WEEKLY;UNTIL=19971007T000000Z;WKST=SU;BYDAY=TU,TH",
+		"FREQ=MONTHLY;INTERVAL=2;COUNT=10;BYDAY=1SU,-1SU",
+		"FREQ=YEARLY;UNTIL=20000131;BYMONTH=1;BYDAY=SU,MO",
+		"FREQ=DAILY;INTERVAL=1;X-NAME=value",
+	}
+	for _, rule := range tests {
+		r, err := ParseRecurrence(rule)
+		if err != nil {
+			t.Errorf("%s: %v", rule, err)
+			continue
+		}
+		if got := r.String(); got != rule {
+			t.Errorf("got %q, want %q", got, rule)
+		}
+	}
+	for _, rule := range []string{"FREQ=SOMETIMES", "FREQ=DAILY;FREQ=DAILY", "COUNT", "FREQ=DAILY;BYDAY=XX"} {
+		if _, err := ParseRecurrence(rule); err == nil {
+			t.Errorf("%s: got no error", rule)
+		}
+	}
+}
+
+func TestRecurrenceJSON(t *testing.T) {
+	const rule = "FREQ=WEEKLY;COUNT=4;BYDAY=MO,WE"
+	r, err := ParseRecurrence(rule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := json.Marshal(Event{RRule: r})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), `"RRule":"`+rule+`"`) {
+		t.Errorf("got %s", b)
+	}
+	var e Event
+	if err := json.Unmarshal(b, &e); err != nil {
+		t.Fatal(err)
+	}
+	if e.RRule == nil || e.RRule.String() != rule {
+		t.Errorf("got RRule %+v", e.RRule)
+	}
+
+	tests := []struct {
+		name, in, want string
+	}{
+		{"text", `"FREQ=DAILY;COUNT=2"`, "FREQ=DAILY;COUNT=2"},
+		{"earlier versions", `{"Rule1":"FREQ=WEEKLY","Rule2":"BYDAY=MO","Rule3":"COUNT=4","Rule4":"","Rule5":""}`, "FREQ=WEEKLY;BYDAY=MO;COUNT=4"},
+		{"empty", `{"Rule1":"","Rule2":"","Rule3":"","Rule4":"","Rule5":""}`, ""},
+	}
+	for _, test := range tests {
+		var r Recurrence
+		if err := json.Unmarshal([]byte(test.in), &r); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if got := r.String(); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+	var bad Recurrence
+	if err := json.Unmarshal([]byte(`"FREQ=SOMETIMES"`), &bad); err == nil {
+		t.Error("got no error for an unknown frequency")
+	}
+}
+
+func TestJsonToVcalendarRecurrenceRule(t *testing.T) {
+	// the JSON of earlier versions, with the RRULE split into Rule1 to Rule5
+	in := `{"Profile":"VCALENDAR","Version":"2.0","Events":[{"Profile":"VEVENT","UID":"a",` +
+		`"DTStart":{"TZId":"","Type":"","Value":"20240101T100000Z"},` +
+		`"RRule":{"Rule1":"FREQ=WEEKLY","Rule2":"BYDAY=MO","Rule3":"COUNT=4","Rule4":"","Rule5":""}}]}`
+	out := JsonToVcalendar(in)
+	if !strings.Contains(out, "\r\nRRULE:FREQ=WEEKLY;BYDAY=MO;COUNT=4\r\n") {
+		t.Errorf("got %q", out)
+	}
+}
+
+func TestEmptyRecurrenceOccurrences(t *testing.T) {
+	// earlier versions wrote an empty RRule for every event
+	in := `{"UID":"a","DTStart":{"Value":"20240101T100000Z"},"RRule":{"Rule1":"","Rule2":"","Rule3":"","Rule4":"","Rule5":""}}`
+	var e Event
+	if err := json.Unmarshal([]byte(in), &e); err != nil {
+		t.Fatal(err)
+	}
+	it, err := e.Occurrences(time.Time{}, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for it.Next() {
+		n++
+	}
+	if n != 1 {
+		t.Errorf("got %d occurrences, want 1", n)
+	}
+}
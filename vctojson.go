@@ -1,37 +1,51 @@
 package golib_vcard
 
 import (
-	"encoding/json"
 	"fmt"
 )
 
 //Vcard 转为Json
 func VcardToJson(tVcard string) string {
-	var c Card
-	if err := Unmarshal([]byte(tVcard), &c); err != nil {
+	d, err := ConvertVcardToJson([]byte(tVcard), nil)
+	if err != nil {
 		fmt.Println(err)
 		fmt.Println("转码失败")
 	}
-	d, _ := json.Marshal(c)
 	return string(d)
 }
 
 //Vcalendar转为Json
 func VcalendarToJson(tVcalendar string) string {
-	var ca Calendar
-	if err := Unmarshal([]byte(tVcalendar), &ca); err != nil {
+	d, err := ConvertVcalendarToJson([]byte(tVcalendar), nil)
+	if err != nil {
 		fmt.Printf("转码失败")
 	}
-	d, _ := json.Marshal(ca)
 	return string(d)
 }
 
 //短信格式转为Json
 func BjsonToJson(tBjson string) string {
-	var ca BJSON
-	if err := Unmarshal([]byte(tBjson), &ca); err != nil {
+	d, err := ConvertBjsonToJson([]byte(tBjson), nil)
+	if err != nil {
 		fmt.Printf("转码失败")
 	}
-	d, _ := json.Marshal(ca)
 	return string(d)
 }
+
+//Vcard 转为Json，失败时返回 *ConvertError 及已转换部分的 Json
+func ConvertVcardToJson(data []byte, opts *JsonOptions) ([]byte, error) {
+	var c Card
+	return decodeToJson(data, &c, "VCARD", opts)
+}
+
+//Vcalendar转为Json，失败时返回 *ConvertError 及已转换部分的 Json
+func ConvertVcalendarToJson(data []byte, opts *JsonOptions) ([]byte, error) {
+	var ca Calendar
+	return decodeToJson(data, &ca, "VCALENDAR", opts)
+}
+
+//短信格式转为Json，失败时返回 *ConvertError 及已转换部分的 Json
+func ConvertBjsonToJson(data []byte, opts *JsonOptions) ([]byte, error) {
+	var ca BJSON
+	return decodeToJson(data, &ca, "JSON", opts)
+}
@@ -0,0 +1,238 @@
+package golib_vcard
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"strings"
+)
+
+// toVersion returns a copy of the VCARD object o converted to the given vCard
+// version. Parameter syntax, PREF, inline binary data and GEO are rewritten
+// to the form of the target version, and properties the version does not
+// define are dropped or, unless drop is set, prefixed with "X-".
+func toVersion(o *Object, version string, drop bool) (*Object, error) {
+	switch version {
+	case "2.1", "3.0", "4.0":
+	default:
+		return nil, errors.New("Unsupported vCard version " + version)
+	}
+	c := &Object{Profile: o.Profile, Objects: o.Objects}
+	c.Properties = append(c.Properties, &ContentLine{Name: "VERSION", Value: StructuredValue{Value{version}}})
+	for _, cl := range o.Properties {
+		name := strings.ToUpper(cl.Name)
+		if name == "VERSION" {
+			continue
+		}
+		ncl := &ContentLine{Group: cl.Group, Name: cl.Name, Params: make(map[string]Value)}
+		for k, v := range cl.Params {
+			ncl.Params[strings.ToUpper(k)] = append(Value{}, v...)
+		}
+		for _, v := range cl.Value {
+			ncl.Value = append(ncl.Value, append(Value{}, v...))
+		}
+		if versions, ok := vcardVersions[name]; ok && !strings.Contains(versions, version) {
+			if drop {
+				continue
+			}
+			ncl.Name = "X-" + ncl.Name
+		}
+		convertPref(ncl, version)
+		switch name {
+		case "PHOTO", "LOGO", "SOUND", "KEY":
+			if err := convertBinary(ncl, version); err != nil {
+				return nil, err
+			}
+		case "GEO":
+			convertGeo(ncl, version)
+		}
+		convertEncoding(ncl, version)
+		if len(ncl.Params) == 0 {
+			ncl.Params = nil
+		}
+		c.Properties = append(c.Properties, ncl)
+	}
+	return c, nil
+}
+
+// convertPref moves the preference between TYPE=pref (2.1, 3.0) and the
+// PREF parameter (4.0).
+func convertPref(cl *ContentLine, version string) {
+	var types Value
+	pref := ""
+	for _, t := range cl.Params["TYPE"] {
+		for _, tt := range strings.Split(t, ",") {
+			if strings.EqualFold(tt, "pref") {
+				pref = "1"
+			} else if tt != "" {
+				types = append(types, tt)
+			}
+		}
+	}
+	if p := cl.Params["PREF"].GetText(); p != "" {
+		pref = p
+	}
+	delete(cl.Params, "PREF")
+	if pref != "" {
+		switch version {
+		case "4.0":
+			cl.Params["PREF"] = Value{pref}
+		case "3.0":
+			types = append(types, "pref")
+		default:
+			types = append(types, "PREF")
+		}
+	}
+	if len(types) > 0 {
+		cl.Params["TYPE"] = types
+	} else {
+		delete(cl.Params, "TYPE")
+	}
+}
+
+// convertBinary rewrites a PHOTO, LOGO, SOUND or KEY between an inline data:
+// URI (4.0), ENCODING=b (3.0) or ENCODING=BASE64 (2.1) with a TYPE format,
+// and VALUE=uri (3.0) or VALUE=URL (2.1) references.
+func convertBinary(cl *ContentLine, version string) error {
+	value := cl.Value.GetText()
+	if value == "" {
+		return nil
+	}
+	format := ""
+	var types Value
+	for _, t := range cl.Params["TYPE"] {
+		if mediaTypeOf(t) != "" && format == "" {
+			format = t
+		} else {
+			types = append(types, t)
+		}
+	}
+	mediaType := cl.Params["MEDIATYPE"].GetText()
+	if mediaType == "" {
+		mediaType = mediaTypeOf(format)
+	}
+	enc := strings.ToUpper(cl.Params["ENCODING"].GetText())
+	inline := enc == "B" || enc == "BASE64"
+	if strings.HasPrefix(strings.ToLower(value), "data:") {
+		mt, data, err := parseDataURI(value)
+		if err != nil {
+			return err
+		}
+		if mt != "" {
+			mediaType = mt
+		}
+		value = base64.StdEncoding.EncodeToString(data)
+		inline = true
+	}
+
+	delete(cl.Params, "ENCODING")
+	delete(cl.Params, "MEDIATYPE")
+	delete(cl.Params, "VALUE")
+	if len(types) > 0 {
+		cl.Params["TYPE"] = types
+	} else {
+		delete(cl.Params, "TYPE")
+	}
+	if version == "4.0" {
+		if inline {
+			value = "data:" + mediaType + ";base64," + strings.Join(strings.Fields(value), "")
+		} else if mediaType != "" {
+			cl.Params["MEDIATYPE"] = Value{mediaType}
+		}
+		cl.Value = StructuredValue{Value{value}}
+		return nil
+	}
+
+	if f := formatOf(mediaType); f != "" {
+		cl.Params["TYPE"] = append(cl.Params["TYPE"], f)
+	}
+	switch {
+	case inline && version == "3.0":
+		cl.Params["ENCODING"] = Value{"b"}
+	case inline:
+		cl.Params["ENCODING"] = Value{"BASE64"}
+	case version == "3.0":
+		cl.Params["VALUE"] = Value{"uri"}
+	default:
+		cl.Params["VALUE"] = Value{"URL"}
+	}
+	cl.Value = StructuredValue{Value{value}}
+	return nil
+}
+
+// parseDataURI returns the media type and data of an RFC 2397 data: URI.
+func parseDataURI(uri string) (mediaType string, data []byte, err error) {
+	i := strings.IndexByte(uri, ',')
+	if i < 0 {
+		return "", nil, errors.New("Malformed data URI")
+	}
+	meta, payload := uri[len("data:"):i], uri[i+1:]
+	isBase64 := strings.HasSuffix(strings.ToLower(meta), ";base64")
+	if isBase64 {
+		meta = meta[:len(meta)-len(";base64")]
+	}
+	mediaType = strings.Split(meta, ";")[0]
+	if isBase64 {
+		data, err = decodeBase64(payload)
+		return mediaType, data, err
+	}
+	s, err := url.PathUnescape(payload)
+	return mediaType, []byte(s), err
+}
+
+// decodeBase64 decodes standard base64, ignoring whitespace left over from
+// folding and missing padding.
+func decodeBase64(s string) ([]byte, error) {
+	s = strings.TrimRight(strings.Join(strings.Fields(s), ""), "=")
+	return base64.RawStdEncoding.DecodeString(s)
+}
+
+// convertGeo rewrites GEO between "lat;lon" (2.1, 3.0) and "geo:lat,lon"
+// (4.0).
+func convertGeo(cl *ContentLine, version string) {
+	value := cl.Value.GetText()
+	isURI := strings.HasPrefix(strings.ToLower(value), "geo:")
+	if version == "4.0" && !isURI && len(cl.Value) == 2 {
+		cl.Value = StructuredValue{Value{"geo:" + value + "," + cl.Value[1].GetText()}}
+	} else if version != "4.0" && isURI {
+		coords := strings.SplitN(strings.SplitN(value[len("geo:"):], ";", 2)[0], ",", 3)
+		if len(coords) >= 2 {
+			cl.Value = StructuredValue{Value{coords[0]}, Value{coords[1]}}
+		}
+		delete(cl.Params, "VALUE")
+	}
+}
+
+// convertEncoding fixes up the remaining parameters: vCard 2.1 gets bare
+// TYPE parameters and a CHARSET for non-ASCII values, later versions have no
+// CHARSET and spell ENCODING=BASE64 as "b".
+func convertEncoding(cl *ContentLine, version string) {
+	if enc := cl.Params["ENCODING"].GetText(); enc != "" {
+		switch strings.ToUpper(enc) {
+		case "B", "BASE64":
+			if version == "2.1" {
+				cl.Params["ENCODING"] = Value{"BASE64"}
+			} else {
+				cl.Params["ENCODING"] = Value{"b"}
+			}
+		}
+	}
+	if version != "2.1" {
+		delete(cl.Params, "CHARSET")
+		return
+	}
+	for _, t := range cl.Params["TYPE"] {
+		cl.Params[strings.ToUpper(t)] = nil
+	}
+	delete(cl.Params, "TYPE")
+	for _, v := range cl.Value {
+		for _, vv := range v {
+			for i := 0; i < len(vv); i++ {
+				if vv[i] >= 0x80 {
+					cl.Params["CHARSET"] = Value{"UTF-8"}
+					return
+				}
+			}
+		}
+	}
+}
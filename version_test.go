@@ -0,0 +1,91 @@
+package golib_vcard
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestEncoderVersion(t *testing.T) {
+	const in = "BEGIN:VCARD\r\nVERSION:3.0\r\nFN:Alice\r\nTEL;TYPE=home,pref:+1 555\r\nPHOTO;ENCODING=b;TYPE=JPEG:/9j/4AAQ\r\nURL:http://example.com/a,b\r\nEND:VCARD\r\n"
+	tests := []struct {
+		version string
+		want    []string
+	}{
+		{"", []string{
+			"VERSION:3.0\r\n",
+			"TEL;TYPE=home,pref:+1 555\r\n",
+			"PHOTO;TYPE=JPEG;ENCODING=b:/9j/4AAQ\r\n",
+			"URL:http://example.com/a,b\r\n",
+		}},
+		{"4.0", []string{
+			"VERSION:4.0\r\n",
+			"TEL;TYPE=home;PREF=1:+1 555\r\n",
+			"PHOTO:data:image/jpeg;base64,/9j/4AAQ\r\n",
+			"URL:http://example.com/a,b\r\n",
+		}},
+		{"2.1", []string{
+			"VERSION:2.1\r\n",
+			"TEL;HOME;PREF:+1 555\r\n",
+			"PHOTO;ENCODING=BASE64;JPEG:/9j/4AAQ\r\n",
+		}},
+	}
+	for _, test := range tests {
+		o, err := NewDecoder(strings.NewReader(in)).ReadObject()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var b bytes.Buffer
+		enc := NewEncoder(&b)
+		enc.Version = test.version
+		if err := enc.WriteObject(o); err != nil {
+			t.Errorf("version %q: %v", test.version, err)
+			continue
+		}
+		for _, line := range test.want {
+			if !strings.Contains(b.String(), line) {
+				t.Errorf("version %q: got %q, want line %q", test.version, b.String(), line)
+			}
+		}
+	}
+}
+
+func TestEncoderVersionUnsupported(t *testing.T) {
+	o := &Object{Profile: "VCARD", Properties: []*ContentLine{
+		{Name: "FN", Value: StructuredValue{Value{"Alice"}}},
+		{Name: "KIND", Value: StructuredValue{Value{"individual"}}},
+	}}
+	for _, drop := range []bool{false, true} {
+		var b bytes.Buffer
+		enc := NewEncoder(&b)
+		enc.Version = "3.0"
+		enc.DropUnsupported = drop
+		if err := enc.WriteObject(o); err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Contains(b.String(), "X-KIND:individual\r\n"); got == drop {
+			t.Errorf("DropUnsupported %v: got %q", drop, b.String())
+		}
+		if strings.Contains(b.String(), "\r\nKIND:") {
+			t.Errorf("DropUnsupported %v: KIND written to vCard 3.0", drop)
+		}
+	}
+}
+
+func TestEncoderURIValue(t *testing.T) {
+	const in = "BEGIN:VCARD\r\nVERSION:4.0\r\nFN:Alice\r\n" +
+		"TEL;VALUE=uri:tel:+1-555-555-5555;ext=5555\r\n" +
+		"PHOTO:data:image/jpeg;base64,/9j/4AAQ\r\n" +
+		"END:VCARD\r\n"
+	o, err := NewDecoder(strings.NewReader(in)).ReadObject()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	if err := NewEncoder(&b).WriteObject(o); err != nil {
+		t.Fatal(err)
+	}
+	if b.String() != in {
+		t.Errorf("got %q, want %q", b.String(), in)
+	}
+}
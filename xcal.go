@@ -0,0 +1,251 @@
+package golib_vcard
+
+import (
+	"encoding/xml"
+	"errors"
+	"strings"
+)
+
+// XCalNamespace is the XML namespace of xCal documents.
+const XCalNamespace = "urn:ietf:params:xml:ns:icalendar-2.0"
+
+// xcalComponents names the elements of the components of structured
+// properties.
+var xcalComponents = map[string][]string{
+	"GEO":            {"latitude", "longitude"},
+	"REQUEST-STATUS": {"code", "description", "data"},
+}
+
+// xcalParamTypes lists the parameters whose values are not text.
+var xcalParamTypes = map[string]string{
+	"ALTREP":         "uri",
+	"DELEGATED-FROM": "cal-address",
+	"DELEGATED-TO":   "cal-address",
+	"DIR":            "uri",
+	"MEMBER":         "cal-address",
+	"RSVP":           "boolean",
+	"SENT-BY":        "cal-address",
+}
+
+// MarshalXCal returns the RFC 6321 xCal encoding of the VCALENDAR object o and
+// all its components:
+//
+//	<icalendar xmlns="urn:ietf:params:xml:ns:icalendar-2.0">
+//	  <vcalendar>
+//	    <properties><version><text>2.0</text></version>...</properties>
+//	    <components><vevent>...</vevent></components>
+//	  </vcalendar>
+//	</icalendar>
+//
+// Since it works on Object, components the Calendar struct does not model
+// are written as well. Dates, times and UTC offsets use the extended format,
+// RRULE, periods, GEO and REQUEST-STATUS their XML structure, and properties
+// unknown to iCalendar keep their text form in an <unknown> element.
+func MarshalXCal(o *Object) ([]byte, error) {
+	root := &xmlNode{name: "icalendar", attrs: []xml.Attr{{Name: xml.Name{Local: "xmlns"}, Value: XCalNamespace}}}
+	if err := xcalComponent(root, o); err != nil {
+		return nil, err
+	}
+	return root.marshal()
+}
+
+func xcalComponent(parent *xmlNode, o *Object) error {
+	if o.Profile == "" {
+		return errors.New("No profile set.")
+	}
+	comp := parent.add(strings.ToLower(o.Profile))
+	if len(o.Properties) > 0 {
+		props := comp.add("properties")
+		for _, cl := range o.Properties {
+			xcalProperty(props, cl)
+		}
+	}
+	if len(o.Objects) > 0 {
+		comps := comp.add("components")
+		for _, so := range o.Objects {
+			if err := xcalComponent(comps, so); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func xcalProperty(parent *xmlNode, cl *ContentLine) {
+	name := strings.ToUpper(cl.Name)
+	prop := parent.add(strings.ToLower(cl.Name))
+	typ := jcal.defaultType(name)
+	xmlParameters(prop, cl.Params, xcalParamTypes, &typ)
+
+	if comps, ok := xcalComponents[name]; ok {
+		for i, elem := range comps {
+			if i < len(cl.Value) {
+				prop.addText(elem, strings.Join(cl.Value[i], ","))
+			}
+		}
+		return
+	}
+	switch {
+	case typ == "recur":
+		recur := prop.add("recur")
+		for _, comp := range cl.Value {
+			if len(comp) == 0 {
+				continue
+			}
+			kv := strings.SplitN(comp[0], "=", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			part := strings.ToLower(kv[0])
+			for _, v := range append(Value{kv[1]}, comp[1:]...) {
+				if part == "until" {
+					v = extendedDateTime("date-time", v)
+				}
+				recur.addText(part, v)
+			}
+		}
+		return
+	case typ == "unknown" || len(cl.Value) > 1:
+		prop.addText("unknown", escapeValue(cl.Value))
+		return
+	case len(cl.Value) == 0 || len(cl.Value[0]) == 0:
+		prop.add(typ)
+		return
+	}
+	for _, v := range cl.Value[0] {
+		switch typ {
+		case "period":
+			period := prop.add("period")
+			start, end := v, ""
+			if i := strings.IndexByte(v, '/'); i >= 0 {
+				start, end = v[:i], v[i+1:]
+			}
+			period.addText("start", extendedDateTime("date-time", start))
+			if strings.HasPrefix(strings.TrimLeft(end, "+-"), "P") {
+				period.addText("duration", end)
+			} else if end != "" {
+				period.addText("end", extendedDateTime("date-time", end))
+			}
+		case "date", "date-time", "time", "utc-offset":
+			prop.addText(typ, extendedDateTime(typ, v))
+		default:
+			prop.addText(typ, v)
+		}
+	}
+}
+
+// UnmarshalXCal parses an xCal document into a VCALENDAR object. It reverses
+// MarshalXCal and accepts either an <icalendar> root or a bare <vcalendar>,
+// both in the xCal namespace.
+func UnmarshalXCal(data []byte) (*Object, error) {
+	root, err := parseXML(data)
+	if err != nil {
+		return nil, err
+	}
+	if root.name == "icalendar" && root.space == XCalNamespace {
+		if len(root.children) != 1 {
+			return nil, errors.New("Malformed xCal: expected a single vcalendar")
+		}
+		root = root.children[0]
+	}
+	if root.name != "vcalendar" || root.space != XCalNamespace {
+		return nil, errors.New("Malformed xCal: unexpected element " + xmlName(root))
+	}
+	return xcalObject(root)
+}
+
+func xcalObject(n *xmlNode) (*Object, error) {
+	o := &Object{Profile: strings.ToUpper(n.name)}
+	for _, c := range n.children {
+		switch c.name {
+		case "properties":
+			for _, p := range c.children {
+				cl, err := xcalContentLine(p)
+				if err != nil {
+					return nil, err
+				}
+				o.Properties = append(o.Properties, cl)
+			}
+		case "components":
+			for _, sc := range c.children {
+				so, err := xcalObject(sc)
+				if err != nil {
+					return nil, err
+				}
+				o.Objects = append(o.Objects, so)
+			}
+		default:
+			return nil, errors.New("Malformed xCal: unexpected element " + c.name + " in " + n.name)
+		}
+	}
+	return o, nil
+}
+
+func xcalContentLine(n *xmlNode) (*ContentLine, error) {
+	cl := &ContentLine{Name: strings.ToUpper(n.name)}
+	values := xmlParseParameters(n, cl)
+	def := jcal.defaultType(cl.Name)
+
+	if comps, ok := xcalComponents[cl.Name]; ok {
+		for _, elem := range comps {
+			for _, c := range values {
+				if c.name == elem {
+					cl.Value = append(cl.Value, Value{c.text})
+				}
+			}
+		}
+		return cl, nil
+	}
+
+	v := Value{}
+	typ := ""
+	for _, c := range values {
+		typ = c.name
+		switch typ {
+		case "unknown":
+			sv, err := parseValues(cl.Name, c.text, nil)
+			if err != nil {
+				return nil, err
+			}
+			cl.Value = sv
+			return cl, nil
+		case "recur":
+			for _, part := range c.children {
+				name := strings.ToUpper(part.name)
+				text := part.text
+				if name == "UNTIL" {
+					text = basicDateTime(text)
+				}
+				// parts with several values such as BYDAY repeat the element
+				if last := len(cl.Value) - 1; last >= 0 && strings.HasPrefix(cl.Value[last][0], name+"=") {
+					cl.Value[last] = append(cl.Value[last], text)
+				} else {
+					cl.Value = append(cl.Value, Value{name + "=" + text})
+				}
+			}
+			return xmlValueType(cl, typ, def, true), nil
+		case "period":
+			var start, end string
+			for _, part := range c.children {
+				switch part.name {
+				case "start":
+					start = basicDateTime(part.text)
+				case "end":
+					end = basicDateTime(part.text)
+				case "duration":
+					end = part.text
+				}
+			}
+			if start == "" || end == "" {
+				return nil, errors.New("Malformed xCal: period without a start and an end or duration in " + n.name)
+			}
+			v = append(v, start+"/"+end)
+		case "date", "date-time", "time", "utc-offset":
+			v = append(v, basicDateTime(c.text))
+		default:
+			v = append(v, c.text)
+		}
+	}
+	cl.Value = StructuredValue{v}
+	return xmlValueType(cl, typ, def, true), nil
+}
@@ -0,0 +1,128 @@
+package golib_vcard
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+const xcalSample = "BEGIN:VCALENDAR\r\n" +
+	"VERSION:2.0\r\n" +
+	"PRODID:-//Example Inc.//Example Calendar//EN\r\n" +
+	"X-WR-CALNAME:Work\r\n" +
+	"BEGIN:VEVENT\r\n" +
+	"UID:4088E990AD89CB3DBB484909\r\n" +
+	"DTSTAMP:20080205T191224Z\r\n" +
+	"DTSTART;TZID=America/New_York:20081006T090000\r\n" +
+	"DURATION:PT1H\r\n" +
+	"SUMMARY:Planning meeting\\, room 2\r\n" +
+	"CATEGORIES:MEETING,WORK\r\n" +
+	"GEO:37.386013;-122.082932\r\n" +
+	"RRULE:FREQ=WEEKLY;UNTIL=20081231T000000Z;BYDAY=MO,WE\r\n" +
+	"RDATE;VALUE=PERIOD:20081010T120000Z/PT2H,20081011T120000Z/20081011T140000Z\r\n" +
+	"EXDATE;TZID=America/New_York:20081008T090000\r\n" +
+	"BEGIN:VALARM\r\n" +
+	"ACTION:DISPLAY\r\n" +
+	"TRIGGER:-PT15M\r\n" +
+	"DESCRIPTION:Reminder\r\n" +
+	"END:VALARM\r\n" +
+	"END:VEVENT\r\n" +
+	"BEGIN:VTODO\r\n" +
+	"UID:t\r\n" +
+	"DUE;VALUE=DATE:20081015\r\n" +
+	"END:VTODO\r\n" +
+	"END:VCALENDAR\r\n"
+
+func TestXCalRoundTrip(t *testing.T) {
+	o, err := NewDecoder(strings.NewReader(xcalSample)).ReadObject()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := MarshalXCal(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, elem := range []string{
+		`<icalendar xmlns="urn:ietf:params:xml:ns:icalendar-2.0"><vcalendar>`,
+		`<dtstart><parameters><tzid><text>America/New_York</text></tzid></parameters><date-time>2008-10-06T09:00:00</date-time></dtstart>`,
+		`<rrule><recur><freq>WEEKLY</freq><until>2008-12-31T00:00:00Z</until><byday>MO</byday><byday>WE</byday></recur></rrule>`,
+		`<period><start>2008-10-10T12:00:00Z</start><duration>PT2H</duration></period>`,
+		`<period><start>2008-10-11T12:00:00Z</start><end>2008-10-11T14:00:00Z</end></period>`,
+		`<geo><latitude>37.386013</latitude><longitude>-122.082932</longitude></geo>`,
+		`<components><valarm>`,
+	} {
+		if !bytes.Contains(data, []byte(elem)) {
+			t.Errorf("got %s, want element %s", data, elem)
+		}
+	}
+
+	got, err := UnmarshalXCal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	if err := NewEncoder(&b).WriteObject(got); err != nil {
+		t.Fatal(err)
+	}
+	if b.String() != xcalSample {
+		t.Errorf("got %q, want %q", b.String(), xcalSample)
+	}
+
+	var c Calendar
+	if err := FromObject(&c, got); err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Events) != 1 || c.Events[0].RRule == nil || c.Events[0].RRule.String() != "FREQ=WEEKLY;UNTIL=20081231T000000Z;BYDAY=MO,WE" {
+		t.Errorf("got events %+v", c.Events)
+	}
+}
+
+func TestXCalNamespace(t *testing.T) {
+	const props = `<properties><version><text>2.0</text></version></properties>`
+	tests := []struct {
+		name, doc string
+		ok        bool
+	}{
+		{"icalendar", `<icalendar xmlns="urn:ietf:params:xml:ns:icalendar-2.0"><vcalendar>` + props + `</vcalendar></icalendar>`, true},
+		{"single vcalendar", `<vcalendar xmlns="urn:ietf:params:xml:ns:icalendar-2.0">` + props + `</vcalendar>`, true},
+		{"prefixed", `<x:icalendar xmlns:x="urn:ietf:params:xml:ns:icalendar-2.0"><x:vcalendar><x:properties><x:version><x:text>2.0</x:text></x:version></x:properties></x:vcalendar></x:icalendar>`, true},
+		{"no namespace", `<icalendar><vcalendar>` + props + `</vcalendar></icalendar>`, false},
+		{"other namespace", `<icalendar xmlns="urn:example"><vcalendar>` + props + `</vcalendar></icalendar>`, false},
+		{"xcard namespace", `<vcalendar xmlns="urn:ietf:params:xml:ns:vcard-4.0">` + props + `</vcalendar>`, false},
+		{"wrong element", `<icalendar xmlns="urn:ietf:params:xml:ns:icalendar-2.0"><vevent/></icalendar>`, false},
+	}
+	for _, test := range tests {
+		o, err := UnmarshalXCal([]byte(test.doc))
+		if (err == nil) != test.ok {
+			t.Errorf("%s: got error %v", test.name, err)
+			continue
+		}
+		if test.ok && (o.Profile != "VCALENDAR" || len(o.Properties) != 1 || o.Properties[0].Value.GetText() != "2.0") {
+			t.Errorf("%s: got %+v", test.name, o)
+		}
+	}
+}
+
+func TestXCalPeriod(t *testing.T) {
+	const doc = `<vcalendar xmlns="urn:ietf:params:xml:ns:icalendar-2.0"><properties>` +
+		`<rdate><period><start>2008-10-10T12:00:00Z</start>%s</period></rdate>` +
+		`</properties></vcalendar>`
+	tests := []struct {
+		name, elem, want string
+		ok               bool
+	}{
+		{"duration", `<duration>PT2H</duration>`, "20081010T120000Z/PT2H", true},
+		{"end", `<end>2008-10-10T14:00:00Z</end>`, "20081010T120000Z/20081010T140000Z", true},
+		{"start only", ``, "", false},
+	}
+	for _, test := range tests {
+		o, err := UnmarshalXCal([]byte(strings.Replace(doc, "%s", test.elem, 1)))
+		if (err == nil) != test.ok {
+			t.Errorf("%s: got error %v", test.name, err)
+			continue
+		}
+		if test.ok && o.Properties[0].Value.GetText() != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, o.Properties[0].Value.GetText(), test.want)
+		}
+	}
+}
@@ -0,0 +1,409 @@
+package golib_vcard
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"strings"
+)
+
+// XCardNamespace is the XML namespace of xCard documents.
+const XCardNamespace = "urn:ietf:params:xml:ns:vcard-4.0"
+
+// xmlNode is a generic XML element used while converting to and from xCard
+// and xCal.
+type xmlNode struct {
+	name     string
+	space    string // namespace, only set by parseXML
+	attrs    []xml.Attr
+	children []*xmlNode
+	text     string
+}
+
+func (n *xmlNode) add(name string) *xmlNode {
+	c := &xmlNode{name: name}
+	n.children = append(n.children, c)
+	return c
+}
+
+func (n *xmlNode) addText(name, text string) {
+	n.add(name).text = text
+}
+
+func (n *xmlNode) attr(name string) string {
+	for _, a := range n.attrs {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+func (n *xmlNode) encode(enc *xml.Encoder) error {
+	start := xml.StartElement{Name: xml.Name{Local: n.name}, Attr: n.attrs}
+	if err := enc.EncodeToken(start); err != nil {
+		return err
+	}
+	if n.text != "" {
+		if err := enc.EncodeToken(xml.CharData(n.text)); err != nil {
+			return err
+		}
+	}
+	for _, c := range n.children {
+		if err := c.encode(enc); err != nil {
+			return err
+		}
+	}
+	return enc.EncodeToken(start.End())
+}
+
+func (n *xmlNode) marshal() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteString(xml.Header)
+	enc := xml.NewEncoder(&b)
+	if err := n.encode(enc); err != nil {
+		return nil, err
+	}
+	if err := enc.Flush(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// parseXML reads the root element of data into an xmlNode tree. Character
+// data is only kept for elements without child elements.
+func parseXML(data []byte) (*xmlNode, error) {
+	dec := xml.NewDecoder(bytes.NewReader(data))
+	var stack []*xmlNode
+	var root *xmlNode
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			n := &xmlNode{name: t.Name.Local, space: t.Name.Space, attrs: t.Attr}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.children = append(parent.children, n)
+			} else if root == nil {
+				root = n
+			}
+			stack = append(stack, n)
+		case xml.EndElement:
+			n := stack[len(stack)-1]
+			if len(n.children) > 0 {
+				n.text = ""
+			}
+			stack = stack[:len(stack)-1]
+		case xml.CharData:
+			if len(stack) > 0 {
+				stack[len(stack)-1].text += string(t)
+			}
+		}
+	}
+	if root == nil {
+		return nil, errors.New("Empty XML document")
+	}
+	return root, nil
+}
+
+// xcardComponents names the elements of the components of structured
+// properties. ORG repeats a text element per component.
+var xcardComponents = map[string][]string{
+	"N":            {"surname", "given", "additional", "prefix", "suffix"},
+	"ADR":          {"pobox", "ext", "street", "locality", "region", "code", "country"},
+	"GENDER":       {"sex", "identity"},
+	"CLIENTPIDMAP": {"sourceid", "uri"},
+}
+
+// xcardParamTypes lists the parameters whose values are not text.
+var xcardParamTypes = map[string]string{
+	"PREF":     "integer",
+	"GEO":      "uri",
+	"LANGUAGE": "language-tag",
+}
+
+// MarshalXCard returns the RFC 6351 xCard encoding of the VCARD objects:
+//
+//	<vcards xmlns="urn:ietf:params:xml:ns:vcard-4.0">
+//	  <vcard><fn><text>J. Doe</text></fn>...</vcard>
+//	</vcards>
+//
+// Content lines sharing a group are wrapped in a <group name="..."> element
+// at the position of the first of them. Properties unknown to vCard, and
+// values with several components that xCard defines no elements for, keep
+// their vCard text form in an <unknown> element.
+func MarshalXCard(objects ...*Object) ([]byte, error) {
+	root := &xmlNode{name: "vcards", attrs: []xml.Attr{{Name: xml.Name{Local: "xmlns"}, Value: XCardNamespace}}}
+	for _, o := range objects {
+		if !strings.EqualFold(o.Profile, "VCARD") {
+			return nil, errors.New("Cannot encode " + o.Profile + " as xCard")
+		}
+		vcard := root.add("vcard")
+		groups := make(map[string]*xmlNode)
+		for _, cl := range o.Properties {
+			parent := vcard
+			if cl.Group != "" {
+				if groups[cl.Group] == nil {
+					g := vcard.add("group")
+					g.attrs = []xml.Attr{{Name: xml.Name{Local: "name"}, Value: cl.Group}}
+					groups[cl.Group] = g
+				}
+				parent = groups[cl.Group]
+			}
+			xcardProperty(parent, cl)
+		}
+	}
+	return root.marshal()
+}
+
+func xcardProperty(parent *xmlNode, cl *ContentLine) {
+	name := strings.ToUpper(cl.Name)
+	prop := parent.add(strings.ToLower(cl.Name))
+	typ := jcard.defaultType(name)
+	xmlParameters(prop, cl.Params, xcardParamTypes, &typ)
+
+	if comps, ok := xcardComponents[name]; ok {
+		for i, elem := range comps {
+			var v Value
+			if i < len(cl.Value) {
+				v = cl.Value[i]
+			}
+			if len(v) == 0 {
+				prop.add(elem)
+			}
+			for _, vv := range v {
+				prop.addText(elem, vv)
+			}
+		}
+		return
+	}
+	if name == "ORG" {
+		// one text element per value, as ORG has no value lists and a
+		// comma is part of the name or unit
+		for _, v := range cl.Value {
+			if len(v) == 0 {
+				prop.add("text")
+			}
+			for _, vv := range v {
+				prop.addText("text", vv)
+			}
+		}
+		return
+	}
+	if typ == "unknown" || len(cl.Value) > 1 {
+		prop.addText("unknown", escapeValue(cl.Value))
+		return
+	}
+	if len(cl.Value) == 0 || len(cl.Value[0]) == 0 {
+		prop.add(typ)
+	} else {
+		for _, v := range cl.Value[0] {
+			prop.addText(dateElement(typ, v), v)
+		}
+	}
+}
+
+// dateElement returns the element for a date-and-or-time value, which xCard
+// writes as date, date-time or time depending on its form.
+func dateElement(typ, v string) string {
+	if typ != "date-and-or-time" {
+		return typ
+	}
+	switch i := strings.IndexByte(v, 'T'); {
+	case i == 0:
+		return "time"
+	case i > 0:
+		return "date-time"
+	}
+	return "date"
+}
+
+// xmlParameters adds the <parameters> element for params to prop. The VALUE
+// parameter is not written but stored in typ.
+func xmlParameters(prop *xmlNode, params map[string]Value, types map[string]string, typ *string) {
+	var ps *xmlNode
+	for _, k := range paramNames(params) {
+		if strings.EqualFold(k, "VALUE") {
+			*typ = strings.ToLower(params[k].GetText())
+			continue
+		}
+		if ps == nil {
+			ps = prop.add("parameters")
+		}
+		p := ps.add(strings.ToLower(k))
+		pt := types[strings.ToUpper(k)]
+		if pt == "" {
+			pt = "text"
+		}
+		for _, v := range params[k] {
+			p.addText(pt, v)
+		}
+	}
+}
+
+// escapeValue returns the vCard text form of a value, used for values that
+// have no XML structure.
+func escapeValue(sv StructuredValue) string {
+	var b bytes.Buffer
+	enc := NewEncoder(&b)
+	for si, v := range sv {
+		for vi, vv := range v {
+			enc.writeValue(vv)
+			if vi+1 < len(v) {
+				enc.writeString(",")
+			}
+		}
+		if si+1 < len(sv) {
+			enc.writeString(";")
+		}
+	}
+	return enc.line.String()
+}
+
+// UnmarshalXCard parses an xCard document, either a <vcards> element or a
+// single <vcard>, into VCARD objects. The elements must be in the
+// XCardNamespace.
+func UnmarshalXCard(data []byte) ([]*Object, error) {
+	root, err := parseXML(data)
+	if err != nil {
+		return nil, err
+	}
+	vcards := []*xmlNode{root}
+	if root.name == "vcards" && root.space == XCardNamespace {
+		vcards = root.children
+	}
+	var objects []*Object
+	for _, vc := range vcards {
+		if vc.name != "vcard" || vc.space != XCardNamespace {
+			return nil, errors.New("Malformed xCard: unexpected element " + xmlName(vc))
+		}
+		o := &Object{Profile: "VCARD"}
+		for _, n := range vc.children {
+			if n.name == "group" {
+				for _, gn := range n.children {
+					cl, err := xcardContentLine(gn)
+					if err != nil {
+						return nil, err
+					}
+					cl.Group = n.attr("name")
+					o.Properties = append(o.Properties, cl)
+				}
+				continue
+			}
+			cl, err := xcardContentLine(n)
+			if err != nil {
+				return nil, err
+			}
+			o.Properties = append(o.Properties, cl)
+		}
+		objects = append(objects, o)
+	}
+	return objects, nil
+}
+
+func xcardContentLine(n *xmlNode) (*ContentLine, error) {
+	cl := &ContentLine{Name: strings.ToUpper(n.name)}
+	values := xmlParseParameters(n, cl)
+
+	if comps, ok := xcardComponents[cl.Name]; ok {
+		for _, elem := range comps {
+			v := Value{}
+			for _, c := range values {
+				if c.name == elem {
+					v = append(v, c.text)
+				}
+			}
+			if len(v) == 1 && v[0] == "" {
+				v = Value{}
+			}
+			cl.Value = append(cl.Value, v)
+		}
+		return cl, nil
+	}
+	if cl.Name == "ORG" {
+		for _, c := range values {
+			if c.text == "" {
+				cl.Value = append(cl.Value, Value{})
+			} else {
+				cl.Value = append(cl.Value, Value{c.text})
+			}
+		}
+		return cl, nil
+	}
+
+	v := Value{}
+	typ := ""
+	for _, c := range values {
+		typ = c.name
+		if typ == "unknown" {
+			sv, err := parseValues(cl.Name, c.text, nil)
+			if err != nil {
+				return nil, err
+			}
+			cl.Value = sv
+			return xmlValueType(cl, typ, jcard.defaultType(cl.Name), false), nil
+		}
+		v = append(v, c.text)
+	}
+	cl.Value = StructuredValue{v}
+	return xmlValueType(cl, typ, jcard.defaultType(cl.Name), false), nil
+}
+
+// xmlName returns the name of n qualified by its namespace, if any.
+func xmlName(n *xmlNode) string {
+	if n.space == "" {
+		return n.name
+	}
+	return "{" + n.space + "}" + n.name
+}
+
+// xmlParseParameters fills the parameters of cl from the <parameters> child
+// of n and returns the remaining value elements.
+func xmlParseParameters(n *xmlNode, cl *ContentLine) []*xmlNode {
+	var values []*xmlNode
+	for _, c := range n.children {
+		if c.name != "parameters" {
+			values = append(values, c)
+			continue
+		}
+		for _, p := range c.children {
+			if cl.Params == nil {
+				cl.Params = make(map[string]Value)
+			}
+			name := strings.ToUpper(p.name)
+			for _, pv := range p.children {
+				cl.Params[name] = append(cl.Params[name], pv.text)
+			}
+			if len(p.children) == 0 {
+				cl.Params[name] = append(cl.Params[name], p.text)
+			}
+		}
+	}
+	return values
+}
+
+// xmlValueType sets the VALUE parameter of cl if the value element typ
+// differs from the default type of the property.
+func xmlValueType(cl *ContentLine, typ, def string, upper bool) *ContentLine {
+	if typ == "" || typ == def || typ == "unknown" {
+		return cl
+	}
+	if def == "date-and-or-time" && (typ == "date" || typ == "date-time" || typ == "time") {
+		return cl
+	}
+	if cl.Params == nil {
+		cl.Params = make(map[string]Value)
+	}
+	if upper {
+		typ = strings.ToUpper(typ)
+	}
+	cl.Params["VALUE"] = Value{typ}
+	return cl
+}
@@ -0,0 +1,114 @@
+package golib_vcard
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const xcardSample = "BEGIN:VCARD\r\n" +
+	"VERSION:4.0\r\n" +
+	"FN:Simon Perreault\r\n" +
+	"N:Perreault;Simon;;;ing. jr,M.Sc.\r\n" +
+	"BDAY:--0203\r\n" +
+	"GENDER:O;intersex\r\n" +
+	"LANG;PREF=1:fr\r\n" +
+	"LANG;PREF=2:en\r\n" +
+	"ORG;TYPE=work:Viagenie\\, Inc.;Research\\, East;\r\n" +
+	"ADR;TYPE=work:;Suite D2-630;2875 Laurier;Quebec;QC;G1V 2M2;Canada\r\n" +
+	"TEL;TYPE=work,voice;PREF=1;VALUE=uri:tel:+1-418-656-9254;ext=102\r\n" +
+	"item1.EMAIL;TYPE=work:simon.perreault@viagenie.ca\r\n" +
+	"item1.X-ABLABEL:Office\r\n" +
+	"GEO;TYPE=work:geo:46.772673,-71.282945\r\n" +
+	"X-CUSTOM;X-PARAM=a:one\\,two;three\r\n" +
+	"END:VCARD\r\n"
+
+func TestXCardObjectRoundTrip(t *testing.T) {
+	o, err := NewDecoder(strings.NewReader(xcardSample)).ReadObject()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := MarshalXCard(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, elem := range []string{
+		`<vcards xmlns="urn:ietf:params:xml:ns:vcard-4.0">`,
+		`<org><parameters><type><text>work</text></type></parameters><text>Viagenie, Inc.</text><text>Research, East</text><text></text></org>`,
+		`<n><surname>Perreault</surname><given>Simon</given><additional></additional><prefix></prefix><suffix>ing. jr</suffix><suffix>M.Sc.</suffix></n>`,
+		`<group name="item1"><email><parameters><type><text>work</text></type></parameters><text>simon.perreault@viagenie.ca</text></email><x-ablabel><unknown>Office</unknown></x-ablabel></group>`,
+	} {
+		if !bytes.Contains(data, []byte(elem)) {
+			t.Errorf("got %s, want element %s", data, elem)
+		}
+	}
+
+	objects, err := UnmarshalXCard(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 1 {
+		t.Fatalf("got %d objects, want 1", len(objects))
+	}
+	var b bytes.Buffer
+	if err := NewEncoder(&b).WriteObject(objects[0]); err != nil {
+		t.Fatal(err)
+	}
+	if b.String() != xcardSample {
+		t.Errorf("got %q, want %q", b.String(), xcardSample)
+	}
+}
+
+func TestXCardCardRoundTrip(t *testing.T) {
+	var want Card
+	if err := Unmarshal([]byte(xcardSample), &want); err != nil {
+		t.Fatal(err)
+	}
+	o := &Object{}
+	if err := ToObject(&want, o); err != nil {
+		t.Fatal(err)
+	}
+	data, err := MarshalXCard(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	objects, err := UnmarshalXCard(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got Card
+	if err := FromObject(&got, objects[0]); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	if got.Org.Name != "Viagenie, Inc." || len(got.Org.Units) != 2 || got.Org.Units[0] != "Research, East" {
+		t.Errorf("got Org %+v", got.Org)
+	}
+}
+
+func TestXCardNamespace(t *testing.T) {
+	tests := []struct {
+		name, doc string
+		ok        bool
+	}{
+		{"vcards", `<vcards xmlns="urn:ietf:params:xml:ns:vcard-4.0"><vcard><fn><text>A</text></fn></vcard></vcards>`, true},
+		{"single vcard", `<vcard xmlns="urn:ietf:params:xml:ns:vcard-4.0"><fn><text>A</text></fn></vcard>`, true},
+		{"prefixed", `<v:vcards xmlns:v="urn:ietf:params:xml:ns:vcard-4.0"><v:vcard><v:fn><v:text>A</v:text></v:fn></v:vcard></v:vcards>`, true},
+		{"no namespace", `<vcards><vcard><fn><text>A</text></fn></vcard></vcards>`, false},
+		{"other namespace", `<vcards xmlns="urn:example"><vcard><fn><text>A</text></fn></vcard></vcards>`, false},
+		{"wrong element", `<vcards xmlns="urn:ietf:params:xml:ns:vcard-4.0"><card/></vcards>`, false},
+	}
+	for _, test := range tests {
+		objects, err := UnmarshalXCard([]byte(test.doc))
+		if (err == nil) != test.ok {
+			t.Errorf("%s: got error %v", test.name, err)
+			continue
+		}
+		if test.ok && (len(objects) != 1 || objects[0].Properties[0].Value.GetText() != "A") {
+			t.Errorf("%s: got %+v", test.name, objects)
+		}
+	}
+}